@@ -0,0 +1,118 @@
+package csyaml
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/goccy/go-yaml/lexer"
+	"github.com/goccy/go-yaml/token"
+)
+
+// deleteTag marks a key or sequence item to remove from the previous documents.
+const deleteTag = "!delete"
+
+// fillEmptyDeleteTags gives a value to the !delete tags that have none,
+// like "key: !delete" at the end of a line. The parser would otherwise take the
+// next lines as the tagged value.
+func fillEmptyDeleteTags(data []byte) []byte {
+	if !bytes.Contains(data, []byte(deleteTag)) {
+		return data
+	}
+
+	src := string(data)
+	if !strings.HasSuffix(src, "\n") {
+		// a tag at the very end is dropped by the lexer
+		src += "\n"
+	}
+
+	lines := strings.SplitAfter(src, "\n")
+	tokens := lexer.Tokenize(src)
+
+	// insert from the end, so that the positions of the previous tags don't change
+	for i := len(tokens) - 1; i >= 0; i-- {
+		tk := tokens[i]
+		if tk.Type != token.TagType || tk.Value != deleteTag {
+			continue
+		}
+
+		next := i + 1
+		for next < len(tokens) && tokens[next].Type == token.CommentType {
+			next++
+		}
+
+		// the tag has a value if it's on the same line, or indented on the next lines
+		if next < len(tokens) && (tokens[next].Position.Line == tk.Position.Line || tokens[next].Position.Column > lineIndent(tokens, i)) {
+			continue
+		}
+
+		line := []rune(lines[tk.Position.Line-1])
+		end := tk.Position.Column - 1 + len([]rune(tk.Value))
+		lines[tk.Position.Line-1] = string(line[:end]) + " ~" + string(line[end:])
+	}
+
+	return []byte(strings.Join(lines, ""))
+}
+
+// lineIndent returns the column of the first token on the line of tokens[i].
+func lineIndent(tokens token.Tokens, i int) int {
+	line := tokens[i].Position.Line
+
+	for i > 0 && tokens[i-1].Position.Line == line {
+		i--
+	}
+
+	return tokens[i].Position.Column
+}
+
+// isDeleted tells whether the node at the path is tagged with !delete.
+func (m *merger) isDeleted(path docPath) bool {
+	return m.tags[path.String()] == deleteTag
+}
+
+// checkNoDeletes returns an error if there is a !delete under the path, when
+// there is nothing to delete there.
+func (m *merger) checkNoDeletes(path docPath) error {
+	prefix := path.String()
+
+	for p, tag := range m.tags {
+		if _, ok := cutPathPrefix(p, prefix); ok && tag == deleteTag {
+			return fmt.Errorf("%s: can't delete, key not found", p)
+		}
+	}
+
+	return nil
+}
+
+// deleteItem removes the items of a sequence that match an item of the patch
+// tagged with !delete.
+func deleteItem(path docPath, into []seqItem, item any, matches func(existing, item any) bool) ([]seqItem, error) {
+	kept := make([]seqItem, 0, len(into))
+
+	for _, existing := range into {
+		if !matches(existing.value, item) {
+			kept = append(kept, existing)
+		}
+	}
+
+	if len(kept) == len(into) {
+		return nil, fmt.Errorf("%s: can't delete, no matching item", path)
+	}
+
+	return kept, nil
+}
+
+// sameItem compares the items of a sequence. Scalars are compared by their text,
+// since a tagged value is always decoded as a string.
+func sameItem(a, b any) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+
+	if isMapping(a) || isSequence(a) || isMapping(b) || isSequence(b) {
+		return false
+	}
+
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
@@ -0,0 +1,153 @@
+package csyaml
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/goccy/go-yaml"
+)
+
+// DocumentOptions changes the behavior of MergeDocuments().
+type DocumentOptions struct {
+	MergeOptions
+	// Key is the field that identifies a document, like "name" or "type".
+	// Documents are paired by index if it's empty.
+	Key string
+	// Strict makes it an error for a document of a patch to have no
+	// counterpart in the previous inputs, or to miss the Key field.
+	// Otherwise, such documents are added at the end of the result.
+	Strict bool
+}
+
+// MergeDocuments is like MergeWithOptions, for inputs that contain several
+// documents separated by "---". Each document of a patch is merged into the
+// document with the same index in the previous inputs, or with the same value
+// for opts.Key. The result is a multi-document stream.
+//
+// Empty documents, or documents with only comments, are ignored.
+func MergeDocuments(inputs [][]byte, opts DocumentOptions) (*bytes.Buffer, error) {
+	var docs [][]byte
+
+	for idx, data := range inputs {
+		inputDocs, err := splitNonEmpty(data)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", idx, err)
+		}
+
+		for j, doc := range inputDocs {
+			pos, err := pairDocument(docs, j, doc, opts)
+			if err != nil {
+				return nil, fmt.Errorf("input %d, document %d: %w", idx, j, err)
+			}
+
+			switch {
+			case pos >= 0 && idx > 0:
+				merged, err := MergeWithOptions([][]byte{docs[pos], doc}, opts.MergeOptions)
+				if err != nil {
+					return nil, fmt.Errorf("input %d, document %d: %w", idx, j, err)
+				}
+
+				docs[pos] = merged.Bytes()
+
+				continue
+			case pos >= 0:
+				return nil, fmt.Errorf("input %d, document %d: same %s as document %d", idx, j, opts.Key, pos)
+			case idx > 0 && opts.Strict:
+				return nil, fmt.Errorf("input %d, document %d: no matching document to merge into", idx, j)
+			}
+
+			// get rid of the tags, and check that there is nothing to delete
+			normalized, err := MergeWithOptions([][]byte{doc}, opts.MergeOptions)
+			if err != nil {
+				return nil, fmt.Errorf("input %d, document %d: %w", idx, j, err)
+			}
+
+			docs = append(docs, normalized.Bytes())
+		}
+	}
+
+	buf := &bytes.Buffer{}
+
+	for i, doc := range docs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+
+		buf.Write(doc)
+	}
+
+	return buf, nil
+}
+
+// splitNonEmpty returns the documents of an input, except the empty ones.
+func splitNonEmpty(data []byte) ([][]byte, error) {
+	docs, err := SplitDocuments(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([][]byte, 0, len(docs))
+
+	for i, doc := range docs {
+		empty, err := IsEmptyYAML(bytes.NewReader(fillEmptyDeleteTags(doc)))
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+
+		if !empty {
+			ret = append(ret, doc)
+		}
+	}
+
+	return ret, nil
+}
+
+// documentKey returns the value of a top-level field of a document.
+func documentKey(doc []byte, field string) (any, bool) {
+	var value any
+
+	if err := yaml.UnmarshalWithOptions(fillEmptyDeleteTags(doc), &value, yaml.UseOrderedMap()); err != nil {
+		return nil, false
+	}
+
+	return itemKey(value, field)
+}
+
+// pairDocument returns the position of the document to merge the j-th document
+// of an input into, or -1.
+func pairDocument(docs [][]byte, j int, doc []byte, opts DocumentOptions) (int, error) {
+	if opts.Key == "" {
+		if j < len(docs) {
+			return j, nil
+		}
+
+		return -1, nil
+	}
+
+	value, ok := documentKey(doc, opts.Key)
+	if !ok {
+		if opts.Strict {
+			return -1, fmt.Errorf("no %q field", opts.Key)
+		}
+
+		return -1, nil
+	}
+
+	pos := -1
+
+	for i, existing := range docs {
+		existingValue, ok := documentKey(existing, opts.Key)
+		if !ok || !reflect.DeepEqual(existingValue, value) {
+			continue
+		}
+
+		if pos >= 0 {
+			return -1, fmt.Errorf("documents %d and %d have the same %s", pos, i, opts.Key)
+		}
+
+		pos = i
+	}
+
+	return pos, nil
+}
@@ -0,0 +1,123 @@
+package csyaml_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/csyaml"
+)
+
+func TestMergeDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputs  []string
+		opts    csyaml.DocumentOptions
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "single document",
+			inputs: []string{"a: 1\n", "a: 2\n"},
+			want:   "a: 2\n",
+		},
+		{
+			name: "paired by index",
+			inputs: []string{
+				"a: 1\n---\nb: 1\n",
+				"a: 2\n---\nb: 2\nc: 3\n",
+			},
+			want: "a: 2\n---\nb: 2\nc: 3\n",
+		},
+		{
+			name: "extra documents are added",
+			inputs: []string{
+				"a: 1\n",
+				"# comment only\n---\nb: 1\n---\nc: 1\n",
+			},
+			want: "a: 1\nb: 1\n---\nc: 1\n",
+		},
+		{
+			name: "extra documents in strict mode",
+			inputs: []string{
+				"a: 1\n",
+				"a: 2\n---\nc: 1\n",
+			},
+			opts:    csyaml.DocumentOptions{Strict: true},
+			wantErr: "input 1, document 1: no matching document to merge into",
+		},
+		{
+			name: "paired by key",
+			inputs: []string{
+				"source: file\nfilenames: [/var/log/auth.log]\n---\nsource: journalctl\nlabels: {type: syslog}\n",
+				"source: journalctl\nlabels: {type: journal}\n---\nsource: docker\n---\nsource: file\nfilenames: !append [/var/log/syslog]\n",
+			},
+			opts: csyaml.DocumentOptions{Key: "source"},
+			want: "source: file\nfilenames:\n- /var/log/auth.log\n- /var/log/syslog\n---\n" +
+				"source: journalctl\nlabels:\n  type: journal\n---\n" +
+				"source: docker\n",
+		},
+		{
+			name: "missing key in strict mode",
+			inputs: []string{
+				"source: file\n---\nlabels: {}\n",
+			},
+			opts:    csyaml.DocumentOptions{Key: "source", Strict: true},
+			wantErr: `input 0, document 1: no "source" field`,
+		},
+		{
+			name: "missing key is added",
+			inputs: []string{
+				"source: file\n",
+				"labels: {}\n",
+			},
+			opts: csyaml.DocumentOptions{Key: "source"},
+			want: "source: file\n---\nlabels: {}\n",
+		},
+		{
+			name: "duplicate key",
+			inputs: []string{
+				"source: file\n---\nsource: file\n",
+			},
+			opts:    csyaml.DocumentOptions{Key: "source"},
+			wantErr: "input 0, document 1: same source as document 0",
+		},
+		{
+			name: "merge error",
+			inputs: []string{
+				"a: 1\n---\nb: 1\n",
+				"a: 2\n---\nb: [1]\n",
+			},
+			wantErr: "input 1, document 1: can't merge a sequence into a scalar",
+		},
+		{
+			name: "delete in an unpaired document",
+			inputs: []string{
+				"a: 1\n",
+				"a: 2\n---\nb: !delete\n",
+			},
+			wantErr: "input 1, document 1: b: can't delete, key not found",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			inputs := make([][]byte, len(tc.inputs))
+			for i, s := range tc.inputs {
+				inputs[i] = []byte(s)
+			}
+
+			buf, err := csyaml.MergeDocuments(inputs, tc.opts)
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			require.NotNil(t, buf)
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
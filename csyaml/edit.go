@@ -0,0 +1,398 @@
+package csyaml
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+// EditOptions changes the behavior of SetValue().
+type EditOptions struct {
+	// Create adds the missing keys of the path, as nested block mappings.
+	Create bool
+	// Document is the index of the document to edit, in a multi-document input.
+	Document int
+}
+
+// SetValue changes a scalar of a YAML document, at a path like `api.server.listen_uri`
+// (see Get). Only the text of the value is replaced: the comments, indentation
+// and the rest of the document are untouched. A string keeps the quoting style
+// of the previous value, unless it needs quotes to be read back as a string.
+//
+// Mappings and sequences can't be set. With opts.Create, the missing keys
+// are added at the end of their mapping.
+func SetValue(data []byte, path string, value any, opts EditOptions) ([]byte, error) {
+	p, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return nil, errors.New(yaml.FormatError(err, false, false))
+	}
+
+	if opts.Document < 0 || opts.Document >= len(file.Docs) {
+		return nil, fmt.Errorf("document %d not found, the input has %d", opts.Document, len(file.Docs))
+	}
+
+	e := &editor{
+		src:  string(data),
+		doc:  file.Docs[opts.Document],
+		last: opts.Document == len(file.Docs)-1,
+	}
+
+	node := e.doc.Body
+
+	for i, seg := range p {
+		var child ast.Node
+		if node != nil {
+			child = childNode(node, seg)
+		}
+
+		if child == nil {
+			if !opts.Create {
+				return nil, fmt.Errorf("%s: %w", p[:i+1], ErrPathNotFound)
+			}
+
+			return e.create(p, i, node, value)
+		}
+
+		node = child
+	}
+
+	if node == nil {
+		return nil, fmt.Errorf("document %d is empty", opts.Document)
+	}
+
+	return e.replaceScalar(p, node, value)
+}
+
+// childNode returns the value of a mapping key or a sequence item, or nil.
+func childNode(node ast.Node, seg pathSegment) ast.Node {
+	switch n := unwrapNode(node).(type) {
+	case *ast.MappingNode:
+		if !seg.isKey {
+			return nil
+		}
+
+		for _, item := range n.Values {
+			if nodeKey(item.Key) == seg.key {
+				return item.Value
+			}
+		}
+	case *ast.SequenceNode:
+		if !seg.isKey && seg.index < len(n.Values) {
+			return n.Values[seg.index]
+		}
+	}
+
+	return nil
+}
+
+// editor splices new text into the source of a document.
+type editor struct {
+	src  string
+	doc  *ast.DocumentNode
+	last bool
+}
+
+// offset returns the position in the source of a line and column (in characters).
+func (e *editor) offset(pos *token.Position) int {
+	off := 0
+
+	for line := 1; line < pos.Line; line++ {
+		next := strings.IndexByte(e.src[off:], '\n')
+		if next < 0 {
+			return len(e.src)
+		}
+
+		off += next + 1
+	}
+
+	for col := 1; col < pos.Column && off < len(e.src) && e.src[off] != '\n'; col++ {
+		_, size := utf8.DecodeRuneInString(e.src[off:])
+		off += size
+	}
+
+	return off
+}
+
+// splice returns the source with the text between start and end replaced.
+func (e *editor) splice(start, end int, text string) []byte {
+	return []byte(e.src[:start] + text + e.src[end:])
+}
+
+// scalarEnd returns the end of the text of a scalar that starts at the offset,
+// or -1 if it's not found.
+func (e *editor) scalarEnd(tk *token.Token, start int) int {
+	rest := e.src[start:]
+
+	switch tk.Type {
+	case token.DoubleQuoteType:
+		if quoted, err := strconv.QuotedPrefix(rest); err == nil {
+			return start + len(quoted)
+		}
+
+		// YAML has escapes that Go doesn't know, or line breaks
+		for i := 1; i < len(rest); i++ {
+			switch rest[i] {
+			case '\\':
+				i++
+			case '"':
+				return start + i + 1
+			}
+		}
+	case token.SingleQuoteType:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] != '\'' {
+				continue
+			}
+
+			if i+1 < len(rest) && rest[i+1] == '\'' {
+				i++
+				continue
+			}
+
+			return start + i + 1
+		}
+	default:
+		if strings.HasPrefix(rest, tk.Value) {
+			return start + len(tk.Value)
+		}
+	}
+
+	return -1
+}
+
+// formatScalar returns the text of a value, in the quoting style of the token
+// it replaces if it's a string.
+func formatScalar(value any, style token.Type) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		text, err := yaml.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+
+		decoded, err := decodeValue(text)
+
+		ret := strings.TrimSuffix(string(text), "\n")
+		if err != nil || strings.Contains(ret, "\n") || isMapping(decoded) || isSequence(decoded) {
+			return "", fmt.Errorf("can't set a %T, only scalars", value)
+		}
+
+		return ret, nil
+	}
+
+	switch {
+	case strings.ContainsAny(s, "\r\n"):
+		return strconv.Quote(s), nil
+	case style == token.SingleQuoteType:
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'", nil
+	case style == token.DoubleQuoteType || token.IsNeedQuoted(s):
+		return strconv.Quote(s), nil
+	}
+
+	return s, nil
+}
+
+// replaceScalar replaces the text of an existing value.
+func (e *editor) replaceScalar(path docPath, node ast.Node, value any) ([]byte, error) {
+	scalar := unwrapNode(node)
+
+	switch scalar.(type) {
+	case *ast.MappingNode, *ast.SequenceNode, *ast.LiteralNode, *ast.AliasNode:
+		return nil, fmt.Errorf("%s: can't replace a %s, only scalars", path, scalar.Type())
+	}
+
+	tk := scalar.GetToken()
+
+	text, err := formatScalar(value, tk.Type)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	start := e.offset(tk.Position)
+
+	end := e.scalarEnd(tk, start)
+	if end < 0 {
+		if _, ok := scalar.(*ast.NullNode); ok {
+			// "key:" without a value
+			return e.splice(start, start, " "+text), nil
+		}
+
+		return nil, fmt.Errorf("%s: can't find the value in the source", path)
+	}
+
+	return e.splice(start, end, text), nil
+}
+
+// lastToken returns the token of a node that comes last in the source.
+func lastToken(node ast.Node) *token.Token {
+	var last *token.Token
+
+	ast.Walk(visitFunc(func(n ast.Node) {
+		tk := n.GetToken()
+		if tk == nil {
+			return
+		}
+
+		if last == nil || tk.Position.Line > last.Position.Line ||
+			(tk.Position.Line == last.Position.Line && tk.Position.Column > last.Position.Column) {
+			last = tk
+		}
+	}), node)
+
+	return last
+}
+
+type visitFunc func(ast.Node)
+
+func (f visitFunc) Visit(node ast.Node) ast.Visitor {
+	f(node)
+	return f
+}
+
+// lineAfter returns the start of the line that follows a token and its
+// children, before the blank lines and the comments that are not indented
+// more than the new lines. The second value must be inserted before the new lines.
+func (e *editor) lineAfter(tk *token.Token, indent int) (int, string) {
+	next := tk.Next
+	for next != nil && next.Position.Line == tk.Position.Line {
+		next = next.Next
+	}
+
+	if next == nil && !strings.HasSuffix(e.src, "\n") {
+		return len(e.src), "\n"
+	}
+
+	at := len(e.src)
+	if next != nil {
+		at = e.offset(&token.Position{Line: next.Position.Line, Column: 1})
+	}
+
+	lineStart := e.offset(&token.Position{Line: tk.Position.Line + 1, Column: 1})
+
+	for at > lineStart {
+		prev := strings.LastIndexByte(e.src[:at-1], '\n') + 1
+		line := e.src[prev : at-1]
+		trimmed := strings.TrimLeft(line, " ")
+
+		if trimmed != "" && (trimmed[0] != '#' || len(line)-len(trimmed) > indent) {
+			break
+		}
+
+		at = prev
+	}
+
+	return at, ""
+}
+
+// create adds the keys of path[pos:] in the node found at path[:pos].
+func (e *editor) create(path docPath, pos int, into ast.Node, value any) ([]byte, error) {
+	text, err := formatScalar(value, token.StringType)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, seg := range path[pos:] {
+		if !seg.isKey {
+			return nil, fmt.Errorf("%s: %w, sequence items can't be created", path[:pos+1], ErrPathNotFound)
+		}
+	}
+
+	var (
+		at     int
+		prefix string
+		indent int
+	)
+
+	switch n := unwrapNode(into).(type) {
+	case nil:
+		if !e.last {
+			return nil, fmt.Errorf("%s: the document is empty", path)
+		}
+
+		at, prefix = len(e.src), ""
+		if e.src != "" && !strings.HasSuffix(e.src, "\n") {
+			prefix = "\n"
+		}
+	case *ast.MappingNode:
+		if n.IsFlowStyle {
+			return nil, fmt.Errorf("%s: can't add a key to a flow mapping", path[:pos])
+		}
+
+		indent = n.Values[0].Key.GetToken().Position.Column - 1
+		at, prefix = e.lineAfter(lastToken(n), indent)
+	case *ast.NullNode:
+		// "key:" without a value, the new keys go below
+		mv := e.parentEntry(path[:pos])
+		if mv == nil || e.scalarEnd(n.GetToken(), e.offset(n.GetToken().Position)) >= 0 {
+			return nil, fmt.Errorf("%s: can't add keys to a null value", path[:pos])
+		}
+
+		indent = mv.Key.GetToken().Position.Column - 1 + 2
+		at, prefix = e.lineAfter(mv.Key.GetToken(), indent)
+	default:
+		return nil, fmt.Errorf("%s: can't add keys to a %s", path[:pos], n.Type())
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString(prefix)
+
+	for i, seg := range path[pos:] {
+		sb.WriteString(strings.Repeat(" ", indent+2*i))
+		sb.WriteString(formatKey(seg.key))
+		sb.WriteString(":")
+
+		if i == len(path[pos:])-1 {
+			sb.WriteString(" " + text)
+		}
+
+		sb.WriteString("\n")
+	}
+
+	return e.splice(at, at, sb.String()), nil
+}
+
+// parentEntry returns the mapping entry at a path, if the last segment is a key.
+func (e *editor) parentEntry(path docPath) *ast.MappingValueNode {
+	if len(path) == 0 || !path[len(path)-1].isKey {
+		return nil
+	}
+
+	node := e.doc.Body
+	for _, seg := range path[:len(path)-1] {
+		node = childNode(node, seg)
+	}
+
+	mapping, ok := unwrapNode(node).(*ast.MappingNode)
+	if !ok {
+		return nil
+	}
+
+	for _, item := range mapping.Values {
+		if nodeKey(item.Key) == path[len(path)-1].key {
+			return item
+		}
+	}
+
+	return nil
+}
+
+func formatKey(key string) string {
+	if token.IsNeedQuoted(key) {
+		return strconv.Quote(key)
+	}
+
+	return key
+}
@@ -0,0 +1,204 @@
+package csyaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/csyaml"
+)
+
+func TestSetValue(t *testing.T) {
+	doc := `# main config
+api:
+  server:
+    listen_uri:   "127.0.0.1:8080"   # loopback only
+    name: 'café'
+    enable: true
+
+  # client settings
+  client:
+    url: &url http://localhost
+sources:
+  - type: file
+  - [a, b]
+empty:
+log_level: info
+`
+
+	tests := []struct {
+		name    string
+		doc     string
+		path    string
+		value   any
+		old     string
+		opts    csyaml.EditOptions
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "double quoted",
+			old:   `    listen_uri:   "127.0.0.1:8080"   # loopback only`,
+			path:  "api.server.listen_uri",
+			value: "0.0.0.0:8080",
+			want:  `    listen_uri:   "0.0.0.0:8080"   # loopback only`,
+		},
+		{
+			name:  "single quoted with a non-ascii character",
+			old:   "    name: 'café'",
+			path:  "api.server.name",
+			value: "it's",
+			want:  `    name: 'it''s'`,
+		},
+		{
+			name:  "plain boolean",
+			old:   "    enable: true",
+			path:  "api.server.enable",
+			value: false,
+			want:  "    enable: false",
+		},
+		{
+			name:  "plain string that needs quotes",
+			old:   "log_level: info",
+			path:  "log_level",
+			value: "true",
+			want:  `log_level: "true"`,
+		},
+		{
+			name:  "anchored value",
+			old:   "    url: &url http://localhost",
+			path:  "api.client.url",
+			value: "https://example.com",
+			want:  "    url: &url https://example.com",
+		},
+		{
+			name:  "sequence items",
+			old:   "  - [a, b]",
+			path:  "sources[1][0]",
+			value: 42,
+			want:  "  - [42, b]",
+		},
+		{
+			name:  "empty value",
+			old:   "empty:",
+			path:  "empty",
+			value: "x",
+			want:  "empty: x",
+		},
+		{
+			name:    "mapping",
+			path:    "api.server",
+			value:   "x",
+			wantErr: "api.server: can't replace a Mapping, only scalars",
+		},
+		{
+			name:    "not a scalar value",
+			path:    "log_level",
+			value:   []string{"a"},
+			wantErr: "log_level: can't set a []string, only scalars",
+		},
+		{
+			name:    "not found",
+			path:    "api.server.tls.cert",
+			value:   "x",
+			wantErr: "api.server.tls: path not found",
+		},
+		{
+			name:  "create in a nested mapping",
+			old:   "    enable: true\n\n  # client settings\n",
+			path:  "api.server.tls.cert",
+			value: "/etc/cert.pem",
+			opts:  csyaml.EditOptions{Create: true},
+			want:  "    enable: true\n    tls:\n      cert: /etc/cert.pem\n\n  # client settings\n",
+		},
+		{
+			name:  "create at the top level",
+			old:   "log_level: info\n",
+			path:  "db_config.type",
+			value: "sqlite",
+			opts:  csyaml.EditOptions{Create: true},
+			want:  "log_level: info\ndb_config:\n  type: sqlite\n",
+		},
+		{
+			name:  "create under an empty value",
+			old:   "empty:\nlog_level: info\n",
+			path:  "empty.key",
+			value: 1,
+			opts:  csyaml.EditOptions{Create: true},
+			want:  "empty:\n  key: 1\nlog_level: info\n",
+		},
+		{
+			name:    "create a sequence item",
+			path:    "sources[5].type",
+			value:   "x",
+			opts:    csyaml.EditOptions{Create: true},
+			wantErr: "sources[5]: path not found, sequence items can't be created",
+		},
+		{
+			name:    "create in a flow mapping",
+			doc:     "a: {b: 1}\n",
+			path:    "a.c",
+			value:   2,
+			opts:    csyaml.EditOptions{Create: true},
+			wantErr: "a: can't add a key to a flow mapping",
+		},
+		{
+			name:  "second document",
+			doc:   "a: 1 # one\n---\na: 2 # two\n---\na: 3\n",
+			path:  "a",
+			value: 20,
+			opts:  csyaml.EditOptions{Document: 1},
+			want:  "a: 1 # one\n---\na: 20 # two\n---\na: 3\n",
+		},
+		{
+			name:  "create in a document followed by another",
+			doc:   "a: 1\n\n---\na: 2\n",
+			path:  "b",
+			value: "x",
+			opts:  csyaml.EditOptions{Create: true},
+			want:  "a: 1\nb: x\n\n---\na: 2\n",
+		},
+		{
+			name:  "create in an empty document",
+			doc:   "# nothing yet",
+			path:  "a.b",
+			value: "x",
+			opts:  csyaml.EditOptions{Create: true},
+			want:  "# nothing yet\na:\n  b: x\n",
+		},
+		{
+			name:    "missing document",
+			doc:     "a: 1\n",
+			path:    "a",
+			value:   2,
+			opts:    csyaml.EditOptions{Document: 1},
+			wantErr: "document 1 not found, the input has 1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := doc
+			if tc.doc != "" {
+				input = tc.doc
+			}
+
+			got, err := csyaml.SetValue([]byte(input), tc.path, tc.value, tc.opts)
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			want := tc.want
+			if tc.doc == "" {
+				// only the edited text changes
+				want = strings.Replace(doc, tc.old, tc.want, 1)
+			}
+
+			assert.Equal(t, want, string(got))
+		})
+	}
+}
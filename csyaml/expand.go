@@ -0,0 +1,247 @@
+package csyaml
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+// ExpandOptions changes the behavior of ExpandEnv().
+type ExpandOptions struct {
+	// Allow lists the variables that can be expanded.
+	Allow []string
+	// Prefix allows the variables whose name starts with it, like "CROWDSEC_".
+	// Any variable can be expanded if both Allow and Prefix are empty.
+	Prefix string
+	// Strict makes it an error to use an allowed variable that is not defined.
+	// Otherwise, the reference is left as it is.
+	Strict bool
+	// Lookup returns the value of a variable. Defaults to os.LookupEnv.
+	Lookup func(name string) (string, bool)
+}
+
+func (o ExpandOptions) allowed(name string) bool {
+	if len(o.Allow) == 0 && o.Prefix == "" {
+		return true
+	}
+
+	return slices.Contains(o.Allow, name) || (o.Prefix != "" && strings.HasPrefix(name, o.Prefix))
+}
+
+// ExpandEnv replaces ${VAR} and $VAR with the value of environment variables,
+// in the scalar values of a YAML document only: keys and comments are not changed.
+// Use $$ for a literal $ in a value.
+//
+// A value without quotes is quoted if needed, so that the variables can't change
+// the structure of the document; it's left as is if it becomes a number or a boolean.
+// The input is returned as is if there is nothing to expand.
+func ExpandEnv(data []byte, opts ExpandOptions) ([]byte, error) {
+	if !bytes.Contains(data, []byte("$")) {
+		return data, nil
+	}
+
+	if opts.Lookup == nil {
+		opts.Lookup = os.LookupEnv
+	}
+
+	file, err := parser.ParseBytes(data, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("%s", yaml.FormatError(err, false, false))
+	}
+
+	changed := false
+
+	for _, doc := range file.Docs {
+		walkNodes(doc, nil, nil, func(path docPath, node ast.Node, _ *token.Token) {
+			if err != nil {
+				return
+			}
+
+			var done bool
+
+			switch n := node.(type) {
+			case *ast.StringNode:
+				done, err = expandString(path, n, opts)
+			case *ast.LiteralNode:
+				done, err = expandLiteral(path, n, opts)
+			}
+
+			changed = changed || done
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !changed {
+		return data, nil
+	}
+
+	ret := file.String()
+	if !strings.HasSuffix(ret, "\n") {
+		ret += "\n"
+	}
+
+	return []byte(ret), nil
+}
+
+// expandVars does the replacements in a string.
+func expandVars(path docPath, s string, opts ExpandOptions) (string, error) {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		if s[i+1] == '$' {
+			sb.WriteByte('$')
+			i++
+
+			continue
+		}
+
+		name, end := varName(s, i)
+		if name == "" || !opts.allowed(name) {
+			sb.WriteString(s[i:end])
+			i = end - 1
+
+			continue
+		}
+
+		value, ok := opts.Lookup(name)
+
+		switch {
+		case ok:
+			sb.WriteString(value)
+		case opts.Strict:
+			return "", fmt.Errorf("%s: undefined variable %s", path, name)
+		default:
+			sb.WriteString(s[i:end])
+		}
+
+		i = end - 1
+	}
+
+	return sb.String(), nil
+}
+
+// varName returns the name of the variable referenced at s[i] (a '$') and the
+// position after the reference. The name is empty if it's not a reference.
+func varName(s string, i int) (string, int) {
+	if s[i+1] == '{' {
+		j := strings.IndexByte(s[i+2:], '}')
+		if j < 0 {
+			return "", i + 1
+		}
+
+		return s[i+2 : i+2+j], i + 3 + j
+	}
+
+	j := i + 1
+	for j < len(s) && (s[j] == '_' || ('a' <= s[j] && s[j] <= 'z') || ('A' <= s[j] && s[j] <= 'Z') || ('0' <= s[j] && s[j] <= '9')) {
+		j++
+	}
+
+	return s[i+1 : j], j
+}
+
+// plainScalar tells whether a value can be written without quotes, and
+// is still read as the same string, or as a number or a boolean.
+func plainScalar(value string) bool {
+	if strings.ContainsAny(value, "\r\n") {
+		return false
+	}
+
+	if !token.IsNeedQuoted(value) {
+		return true
+	}
+
+	var decoded any
+	if err := yaml.Unmarshal([]byte(value), &decoded); err != nil {
+		return false
+	}
+
+	switch decoded.(type) {
+	case bool, int, int64, uint64, float64:
+		return true
+	}
+
+	return false
+}
+
+func expandString(path docPath, node *ast.StringNode, opts ExpandOptions) (bool, error) {
+	if !strings.Contains(node.Value, "$") {
+		return false, nil
+	}
+
+	value, err := expandVars(path, node.Value, opts)
+	if err != nil {
+		return false, err
+	}
+
+	if value == node.Value {
+		return false, nil
+	}
+
+	node.Value = value
+
+	if node.Token.Type != token.SingleQuoteType && node.Token.Type != token.DoubleQuoteType && !plainScalar(value) {
+		node.Token.Type = token.DoubleQuoteType
+	}
+
+	return true, nil
+}
+
+// expandLiteral expands the variables of a block scalar (| or >). It's printed
+// from the source text, so the replacements are done there.
+func expandLiteral(path docPath, node *ast.LiteralNode, opts ExpandOptions) (bool, error) {
+	tk := node.Value.GetToken()
+	if !strings.Contains(tk.Origin, "$") {
+		return false, nil
+	}
+
+	var multiline string
+
+	lookup := opts.Lookup
+	opts.Lookup = func(name string) (string, bool) {
+		value, ok := lookup(name)
+		if ok && strings.ContainsAny(value, "\r\n") {
+			multiline = name
+		}
+
+		return value, ok
+	}
+
+	origin, err := expandVars(path, tk.Origin, opts)
+	if err != nil {
+		return false, err
+	}
+
+	if multiline != "" {
+		// the lines would not be indented
+		return false, fmt.Errorf("%s: variable %s has line breaks, it can't be used in a block scalar", path, multiline)
+	}
+
+	if origin == tk.Origin {
+		return false, nil
+	}
+
+	tk.Origin = origin
+
+	node.Value.Value, err = expandVars(path, node.Value.Value, opts)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
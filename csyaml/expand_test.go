@@ -0,0 +1,144 @@
+package csyaml_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/csyaml"
+)
+
+func TestExpandEnv(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		switch name {
+		case "CS_PORT":
+			return "8080", true
+		case "CS_HOST":
+			return "localhost", true
+		case "CS_KEY":
+			return "a: b # not a comment", true
+		case "CS_QUOTE":
+			return `it's "quoted"`, true
+		case "CS_LINES":
+			return "one\ntwo", true
+		case "HOME":
+			return "/home/user", true
+		case "EMPTY":
+			return "", true
+		}
+
+		return "", false
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		opts    csyaml.ExpandOptions
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "nothing to expand",
+			input: "a:   1 # $HOME\n",
+			want:  "a:   1 # $HOME\n",
+		},
+		{
+			name:  "plain values",
+			input: "# $HOME\nuri: ${CS_HOST}:$CS_PORT\nport: $CS_PORT\n$HOME: 1\n",
+			want:  "# $HOME\nuri: localhost:8080\nport: 8080\n$HOME: 1\n",
+		},
+		{
+			name:  "plain value that must be quoted",
+			input: "key: $CS_KEY\nlist: [$CS_KEY]\nempty: $EMPTY\n",
+			want:  "key: \"a: b # not a comment\"\nlist: [\"a: b # not a comment\"]\nempty: \"\"\n",
+		},
+		{
+			name:  "quoted values",
+			input: "single: 'x $CS_QUOTE'\ndouble: \"$CS_LINES\"\n",
+			want:  "single: 'x it''s \"quoted\"'\ndouble: \"one\\ntwo\"\n",
+		},
+		{
+			name:  "escaped dollar",
+			input: "price: $$HOME and $$$HOME\n",
+			want:  "price: $HOME and $/home/user\n",
+		},
+		{
+			name:  "undefined variable is left as is",
+			input: "a: $UNDEFINED ${ALSO_UNDEFINED} $HOME\n",
+			want:  "a: $UNDEFINED ${ALSO_UNDEFINED} /home/user\n",
+		},
+		{
+			name:    "undefined variable in strict mode",
+			input:   "a:\n  b: [x, $UNDEFINED]\n",
+			opts:    csyaml.ExpandOptions{Strict: true},
+			wantErr: "a.b[1]: undefined variable UNDEFINED",
+		},
+		{
+			name:  "allowlist and prefix",
+			input: "a: $HOME $CS_PORT $EMPTY$UNDEFINED\n",
+			opts:  csyaml.ExpandOptions{Allow: []string{"EMPTY"}, Prefix: "CS_", Strict: true},
+			want:  "a: $HOME 8080 $UNDEFINED\n",
+		},
+		{
+			name:  "block scalar",
+			input: "script: |\n  cd $HOME\n  echo $$CS_PORT\nnext: 1\n",
+			want:  "script: |\n  cd /home/user\n  echo $CS_PORT\nnext: 1\n",
+		},
+		{
+			name:    "line breaks in a block scalar",
+			input:   "script: |\n  $CS_LINES\n",
+			wantErr: "script: variable CS_LINES has line breaks, it can't be used in a block scalar",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.Lookup = lookup
+
+			got, err := csyaml.ExpandEnv([]byte(tc.input), tc.opts)
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestMergedPatchContentExpandEnv(t *testing.T) {
+	t.Setenv("CS_TEST_LISTEN", "0.0.0.0:8080")
+
+	dirPath := t.TempDir()
+
+	configPath := filepath.Join(dirPath, "config.yaml")
+	patchPath := filepath.Join(dirPath, "config.yaml.local")
+
+	err := os.WriteFile(configPath, []byte("# uses $CS_TEST_LISTEN\nlisten_uri: 127.0.0.1:8080\n"), 0o600)
+	require.NoError(t, err)
+
+	patcher := csyaml.NewPatcher(configPath, ".local")
+	patcher.SetExpandEnv(csyaml.ExpandOptions{Prefix: "CS_TEST_", Strict: true})
+
+	content, err := patcher.MergedPatchContent()
+	require.NoError(t, err)
+	assert.Equal(t, "# uses $CS_TEST_LISTEN\nlisten_uri: 127.0.0.1:8080\n", string(content))
+
+	err = os.WriteFile(patchPath, []byte("listen_uri: ${CS_TEST_LISTEN}\n"), 0o600)
+	require.NoError(t, err)
+
+	content, err = patcher.MergedPatchContent()
+	require.NoError(t, err)
+	assert.Equal(t, "listen_uri: 0.0.0.0:8080\n", string(content))
+
+	err = os.WriteFile(patchPath, []byte("listen_uri: ${CS_TEST_UNDEFINED}\n"), 0o600)
+	require.NoError(t, err)
+
+	_, err = patcher.MergedPatchContent()
+	cstest.RequireErrorContains(t, err, "config.yaml: listen_uri: undefined variable CS_TEST_UNDEFINED")
+}
@@ -0,0 +1,527 @@
+package csyaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// decodeValue decodes the first document of an input, keeping the key order.
+// An empty input is a nil value.
+func decodeValue(data []byte) (any, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data), yaml.UseOrderedMap(), yaml.Strict())
+
+	var value any
+	if err := dec.Decode(&value); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.New(yaml.FormatError(err, false, false))
+	}
+
+	return value, nil
+}
+
+func encodeValue(value any) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+
+	if err := yaml.NewEncoder(buf).Encode(value); err != nil {
+		return nil, fmt.Errorf("encoding patched YAML: %w", err)
+	}
+
+	return buf, nil
+}
+
+// ApplyMergePatch applies a JSON Merge Patch (RFC 7386) to a document: the
+// mappings of the patch are merged recursively, a null value removes a key and
+// any other value replaces the previous one. The existing keys keep their order,
+// new keys are added at the end.
+//
+// The document and the patch can be written in YAML or JSON, the result is YAML.
+func ApplyMergePatch(doc, patch []byte) (*bytes.Buffer, error) {
+	target, err := decodeValue(doc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding document: %s", err)
+	}
+
+	value, err := decodeValue(patch)
+	if err != nil {
+		return nil, fmt.Errorf("decoding patch: %s", err)
+	}
+
+	return encodeValue(mergePatch(target, value))
+}
+
+func mergePatch(target, patch any) any {
+	mp, ok := patch.(yaml.MapSlice)
+	if !ok {
+		return patch
+	}
+
+	mt, ok := target.(yaml.MapSlice)
+	if !ok {
+		mt = yaml.MapSlice{}
+	}
+
+	for _, item := range mp {
+		idx := mapIndex(mt, fmt.Sprint(item.Key))
+
+		switch {
+		case item.Value == nil && idx >= 0:
+			mt = slices.Delete(mt, idx, idx+1)
+		case item.Value == nil:
+		case idx >= 0:
+			mt[idx].Value = mergePatch(mt[idx].Value, item.Value)
+		default:
+			mt = append(mt, yaml.MapItem{Key: item.Key, Value: mergePatch(nil, item.Value)})
+		}
+	}
+
+	return mt
+}
+
+// mapIndex returns the position of a key in a mapping, or -1.
+// Keys are compared by their text, like in a JSON pointer.
+func mapIndex(m yaml.MapSlice, key string) int {
+	return slices.IndexFunc(m, func(item yaml.MapItem) bool {
+		return fmt.Sprint(item.Key) == key
+	})
+}
+
+// jsonPointer is a parsed RFC 6901 pointer, like /sources/0/name.
+type jsonPointer []string
+
+func parsePointer(s string) (jsonPointer, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	if s[0] != '/' {
+		return nil, fmt.Errorf("invalid pointer %q: must start with /", s)
+	}
+
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+
+	tokens := strings.Split(s[1:], "/")
+	for i, tok := range tokens {
+		tokens[i] = unescape.Replace(tok)
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses the index of a sequence item. The length is a valid
+// index when adding an item.
+func arrayIndex(tok string, length int, adding bool) (int, error) {
+	if tok == "-" && adding {
+		return length, nil
+	}
+
+	idx, err := strconv.Atoi(tok)
+	if err != nil || idx < 0 || (tok != "0" && tok[0] == '0') || tok[0] == '+' {
+		return 0, fmt.Errorf("invalid index %q", tok)
+	}
+
+	if idx > length || (idx == length && !adding) {
+		return 0, fmt.Errorf("index %d out of range", idx)
+	}
+
+	return idx, nil
+}
+
+// get returns the value a pointer refers to.
+func (p jsonPointer) get(doc any) (any, error) {
+	for _, tok := range p {
+		switch node := doc.(type) {
+		case yaml.MapSlice:
+			idx := mapIndex(node, tok)
+			if idx < 0 {
+				return nil, fmt.Errorf("key %q not found", tok)
+			}
+
+			doc = node[idx].Value
+		case []any:
+			idx, err := arrayIndex(tok, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+
+			doc = node[idx]
+		default:
+			return nil, fmt.Errorf("can't find %q in a %s", tok, describe(doc))
+		}
+	}
+
+	return doc, nil
+}
+
+// update calls fn with the parent of the value a pointer refers to, and the last
+// token of the pointer. The parent is replaced by the return value of fn, and
+// the new document is returned.
+func (p jsonPointer) update(doc any, fn func(parent any, tok string) (any, error)) (any, error) {
+	if len(p) == 1 {
+		return fn(doc, p[0])
+	}
+
+	switch node := doc.(type) {
+	case yaml.MapSlice:
+		idx := mapIndex(node, p[0])
+		if idx < 0 {
+			return nil, fmt.Errorf("key %q not found", p[0])
+		}
+
+		child, err := p[1:].update(node[idx].Value, fn)
+		if err != nil {
+			return nil, err
+		}
+
+		node[idx].Value = child
+
+		return node, nil
+	case []any:
+		idx, err := arrayIndex(p[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := p[1:].update(node[idx], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		node[idx] = child
+
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("can't find %q in a %s", p[0], describe(doc))
+}
+
+func (p jsonPointer) add(doc, value any) (any, error) {
+	if len(p) == 0 {
+		return value, nil
+	}
+
+	return p.update(doc, func(parent any, tok string) (any, error) {
+		switch node := parent.(type) {
+		case yaml.MapSlice:
+			if idx := mapIndex(node, tok); idx >= 0 {
+				node[idx].Value = value
+				return node, nil
+			}
+
+			return append(node, yaml.MapItem{Key: tok, Value: value}), nil
+		case []any:
+			idx, err := arrayIndex(tok, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+
+			return slices.Insert(node, idx, value), nil
+		}
+
+		return nil, fmt.Errorf("can't add %q to a %s", tok, describe(parent))
+	})
+}
+
+func (p jsonPointer) remove(doc any) (any, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+
+	return p.update(doc, func(parent any, tok string) (any, error) {
+		switch node := parent.(type) {
+		case yaml.MapSlice:
+			idx := mapIndex(node, tok)
+			if idx < 0 {
+				return nil, fmt.Errorf("key %q not found", tok)
+			}
+
+			return slices.Delete(node, idx, idx+1), nil
+		case []any:
+			idx, err := arrayIndex(tok, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+
+			return slices.Delete(node, idx, idx+1), nil
+		}
+
+		return nil, fmt.Errorf("can't remove %q from a %s", tok, describe(parent))
+	})
+}
+
+func (p jsonPointer) replace(doc, value any) (any, error) {
+	if len(p) == 0 {
+		return value, nil
+	}
+
+	return p.update(doc, func(parent any, tok string) (any, error) {
+		switch node := parent.(type) {
+		case yaml.MapSlice:
+			idx := mapIndex(node, tok)
+			if idx < 0 {
+				return nil, fmt.Errorf("key %q not found", tok)
+			}
+
+			node[idx].Value = value
+
+			return node, nil
+		case []any:
+			idx, err := arrayIndex(tok, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+
+			node[idx] = value
+
+			return node, nil
+		}
+
+		return nil, fmt.Errorf("can't replace %q in a %s", tok, describe(parent))
+	})
+}
+
+// copyValue returns a deep copy of a decoded value, so that a copied value
+// doesn't change with the original.
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		ret := make(yaml.MapSlice, len(v))
+		for i, item := range v {
+			ret[i] = yaml.MapItem{Key: item.Key, Value: copyValue(item.Value)}
+		}
+
+		return ret
+	case []any:
+		ret := make([]any, len(v))
+		for i, item := range v {
+			ret[i] = copyValue(item)
+		}
+
+		return ret
+	}
+
+	return value
+}
+
+// equalValues compares values like JSON does: mappings are unordered, and
+// numbers are compared by value.
+func equalValues(a, b any) bool {
+	switch va := a.(type) {
+	case yaml.MapSlice:
+		vb, ok := b.(yaml.MapSlice)
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+
+		for _, item := range va {
+			idx := mapIndex(vb, fmt.Sprint(item.Key))
+			if idx < 0 || !equalValues(item.Value, vb[idx].Value) {
+				return false
+			}
+		}
+
+		return true
+	case []any:
+		vb, ok := b.([]any)
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+
+		for i := range va {
+			if !equalValues(va[i], vb[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
+
+// patchOperation is an operation of a JSON Patch.
+type patchOperation struct {
+	op       string
+	path     string
+	from     string
+	value    any
+	hasPath  bool
+	hasFrom  bool
+	hasValue bool
+}
+
+func parseOperation(item any) (patchOperation, error) {
+	fields, ok := item.(yaml.MapSlice)
+	if !ok {
+		return patchOperation{}, fmt.Errorf("expected a mapping, not a %s", describe(item))
+	}
+
+	var op patchOperation
+
+	for _, field := range fields {
+		switch fmt.Sprint(field.Key) {
+		case "op":
+			op.op = fmt.Sprint(field.Value)
+		case "path":
+			op.path = fmt.Sprint(field.Value)
+			op.hasPath = true
+		case "from":
+			op.from = fmt.Sprint(field.Value)
+			op.hasFrom = true
+		case "value":
+			op.value = field.Value
+			op.hasValue = true
+		}
+	}
+
+	if op.op == "" {
+		return patchOperation{}, errors.New(`missing "op"`)
+	}
+
+	// an empty pointer is the whole document, it must be explicit
+	if !op.hasPath {
+		return patchOperation{}, fmt.Errorf(`%s: missing "path"`, op.op)
+	}
+
+	if !op.hasFrom && (op.op == "move" || op.op == "copy") {
+		return patchOperation{}, fmt.Errorf(`%s %s: missing "from"`, op.op, op.path)
+	}
+
+	return op, nil
+}
+
+// String returns the operation as it's shown in the errors.
+func (op patchOperation) String() string {
+	if op.op == "move" || op.op == "copy" {
+		return fmt.Sprintf("%s %s to %s", op.op, op.from, op.path)
+	}
+
+	return op.op + " " + op.path
+}
+
+func (op patchOperation) apply(doc any) (any, error) {
+	path, err := parsePointer(op.path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.op {
+	case "add", "replace", "test":
+		if !op.hasValue {
+			return nil, errors.New(`missing "value"`)
+		}
+	case "move", "copy":
+	case "remove":
+		return path.remove(doc)
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.op)
+	}
+
+	switch op.op {
+	case "add":
+		return path.add(doc, op.value)
+	case "replace":
+		return path.replace(doc, op.value)
+	case "test":
+		value, err := path.get(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if !equalValues(value, op.value) {
+			return nil, errors.New("test failed, the value is different")
+		}
+
+		return doc, nil
+	}
+
+	from, err := parsePointer(op.from)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := from.get(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.op == "copy" {
+		return path.add(doc, copyValue(value))
+	}
+
+	if op.path == op.from {
+		return doc, nil
+	}
+
+	if strings.HasPrefix(op.path, op.from+"/") {
+		return nil, errors.New("can't move a value into itself")
+	}
+
+	if doc, err = from.remove(doc); err != nil {
+		return nil, err
+	}
+
+	return path.add(doc, value)
+}
+
+// ApplyJSONPatch applies the operations of a JSON Patch (RFC 6902) to a
+// document: add, remove, replace, move, copy and test. The key order is kept,
+// new keys are added at the end of their mapping.
+//
+// The document and the patch can be written in YAML or JSON, the result is YAML.
+// The operations are applied in order, and the first that fails stops the patch.
+func ApplyJSONPatch(doc, patch []byte) (*bytes.Buffer, error) {
+	target, err := decodeValue(doc)
+	if err != nil {
+		return nil, fmt.Errorf("decoding document: %s", err)
+	}
+
+	value, err := decodeValue(patch)
+	if err != nil {
+		return nil, fmt.Errorf("decoding patch: %s", err)
+	}
+
+	ops, ok := value.([]any)
+	if !ok && value != nil {
+		return nil, fmt.Errorf("decoding patch: expected a sequence of operations, not a %s", describe(value))
+	}
+
+	for idx, item := range ops {
+		op, err := parseOperation(item)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", idx, err)
+		}
+
+		if target, err = op.apply(target); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", idx, op, err)
+		}
+	}
+
+	return encodeValue(target)
+}
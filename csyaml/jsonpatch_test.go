@@ -0,0 +1,186 @@
+package csyaml_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/csyaml"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	doc := `api:
+  server:
+    listen_uri: 127.0.0.1:8080
+    enable: true
+sources:
+  - name: nginx
+  - name: ssh
+a/b: 1
+m~n: 2
+`
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "add, replace and remove in YAML",
+			patch: "- {op: add, path: /api/server/tls, value: {cert: /etc/cert.pem}}\n- op: replace\n  path: /api/server/enable\n  value: false\n- {op: remove, path: /m~0n}\n",
+			want:  "api:\n  server:\n    listen_uri: 127.0.0.1:8080\n    enable: false\n    tls:\n      cert: /etc/cert.pem\nsources:\n- name: nginx\n- name: ssh\na/b: 1\n",
+		},
+		{
+			name:  "sequence items in JSON",
+			patch: `[{"op": "add", "path": "/sources/1", "value": {"name": "apache"}}, {"op": "add", "path": "/sources/-", "value": {"name": "k8s"}}, {"op": "remove", "path": "/sources/0"}]`,
+			want:  "api:\n  server:\n    listen_uri: 127.0.0.1:8080\n    enable: true\nsources:\n- name: apache\n- name: ssh\n- name: k8s\na/b: 1\nm~n: 2\n",
+		},
+		{
+			name:  "replace sequence items",
+			patch: `[{"op": "replace", "path": "/sources/1", "value": {"name": "apache"}}, {"op": "replace", "path": "/sources/0/name", "value": "k8s"}]`,
+			want:  "api:\n  server:\n    listen_uri: 127.0.0.1:8080\n    enable: true\nsources:\n- name: k8s\n- name: apache\na/b: 1\nm~n: 2\n",
+		},
+		{
+			name:    "replace out of range",
+			patch:   `[{"op": "replace", "path": "/sources/2", "value": 1}]`,
+			wantErr: "operation 0 (replace /sources/2): index 2 out of range",
+		},
+		{
+			name:  "move and copy",
+			patch: `[{"op": "move", "from": "/a~1b", "path": "/api/count"}, {"op": "copy", "from": "/sources/1", "path": "/sources/0"}]`,
+			want:  "api:\n  server:\n    listen_uri: 127.0.0.1:8080\n    enable: true\n  count: 1\nsources:\n- name: ssh\n- name: nginx\n- name: ssh\nm~n: 2\n",
+		},
+		{
+			name:  "test",
+			patch: `[{"op": "test", "path": "/api", "value": {"server": {"enable": true, "listen_uri": "127.0.0.1:8080"}}}, {"op": "test", "path": "/a~1b", "value": 1.0}]`,
+			want:  "api:\n  server:\n    listen_uri: 127.0.0.1:8080\n    enable: true\nsources:\n- name: nginx\n- name: ssh\na/b: 1\nm~n: 2\n",
+		},
+		{
+			name:    "failed test",
+			patch:   `[{"op": "test", "path": "/a~1b", "value": 1}, {"op": "test", "path": "/sources/0/name", "value": "ssh"}]`,
+			wantErr: "operation 1 (test /sources/0/name): test failed, the value is different",
+		},
+		{
+			name:    "missing key",
+			patch:   "- op: replace\n  path: /api/client/url\n  value: x\n",
+			wantErr: `operation 0 (replace /api/client/url): key "client" not found`,
+		},
+		{
+			name:    "index out of range",
+			patch:   `[{"op": "remove", "path": "/sources/2"}]`,
+			wantErr: "operation 0 (remove /sources/2): index 2 out of range",
+		},
+		{
+			name:    "invalid index",
+			patch:   `[{"op": "add", "path": "/sources/01", "value": 1}]`,
+			wantErr: `operation 0 (add /sources/01): invalid index "01"`,
+		},
+		{
+			name:    "move into itself",
+			patch:   `[{"op": "move", "from": "/api", "path": "/api/server/api"}]`,
+			wantErr: "operation 0 (move /api to /api/server/api): can't move a value into itself",
+		},
+		{
+			name:    "missing value",
+			patch:   `[{"op": "add", "path": "/x"}]`,
+			wantErr: `operation 0 (add /x): missing "value"`,
+		},
+		{
+			name:    "missing path",
+			patch:   `[{"op": "add", "value": {"a": 1}}]`,
+			wantErr: `operation 0: add: missing "path"`,
+		},
+		{
+			name:    "missing from",
+			patch:   `[{"op": "copy", "path": "/c"}]`,
+			wantErr: `operation 0: copy /c: missing "from"`,
+		},
+		{
+			name:    "unknown operation",
+			patch:   `[{"op": "delete", "path": "/x"}]`,
+			wantErr: `operation 0 (delete /x): unknown operation "delete"`,
+		},
+		{
+			name:    "invalid pointer",
+			patch:   `[{"op": "remove", "path": "api"}]`,
+			wantErr: `operation 0 (remove api): invalid pointer "api": must start with /`,
+		},
+		{
+			name:    "not a list of operations",
+			patch:   `{"op": "remove", "path": "/api"}`,
+			wantErr: "decoding patch: expected a sequence of operations, not a mapping",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := csyaml.ApplyJSONPatch([]byte(doc), []byte(tc.patch))
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			require.NotNil(t, buf)
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "merge, keep the order",
+			doc:   "a: 1\nb:\n  c: 2\n  d: 3\ne: [1, 2]\n",
+			patch: `{"b": {"c": null, "f": {"g": 4}}, "e": [3], "a": 10}`,
+			want:  "a: 10\nb:\n  d: 3\n  f:\n    g: 4\ne:\n- 3\n",
+		},
+		{
+			name:  "patch in YAML",
+			doc:   "a: 1\n",
+			patch: "a: ~\nb: x\n",
+			want:  "b: x\n",
+		},
+		{
+			name:  "replace a scalar with a mapping",
+			doc:   "a: 1\n",
+			patch: "a: {b: null, c: 2}\n",
+			want:  "a:\n  c: 2\n",
+		},
+		{
+			name:  "patch that is not a mapping",
+			doc:   "a: 1\n",
+			patch: "[1, 2]\n",
+			want:  "- 1\n- 2\n",
+		},
+		{
+			name:    "invalid patch",
+			doc:     "a: 1\n",
+			patch:   "a: [1\n",
+			wantErr: "decoding patch:",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := csyaml.ApplyMergePatch([]byte(tc.doc), []byte(tc.patch))
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			require.NotNil(t, buf)
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"slices"
 
 	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/parser"
 )
 
 // Merge implements a deep-merge over multiple YAML documents, preserving key
@@ -15,16 +17,44 @@ import (
 // Always runs in strict mode: type mismatches or duplicate keys cause an
 // error.
 //
-// Maps are deep-merged; sequences and scalars are replaced by later inputs.
+// Maps are deep-merged; sequences and scalars are replaced by later inputs,
+// unless a patch selects another strategy with a tag (see MergeOptions).
 // Type mismatches result in an error.
 //
+// A key or sequence item tagged with !delete in a patch is removed from the
+// result; it's an error if there is nothing to remove. Sequence items are
+// matched by value with !append or !prepend, or by key with !merge:<field>.
+// A replaced sequence keeps none of the previous items, so deleting one of
+// them requires one of these strategies.
+//
 // Adapted from https://github.com/uber-go/config/tree/master/internal/merge
 func Merge(inputs [][]byte) (*bytes.Buffer, error) {
-	var merged any
+	return MergeWithOptions(inputs, MergeOptions{})
+}
+
+// MergeWithOptions is like Merge, with per-path strategies for sequences.
+func MergeWithOptions(inputs [][]byte, opts MergeOptions) (*bytes.Buffer, error) {
+	buf, _, err := merge(inputs, nil, opts)
+	return buf, err
+}
+
+// merge does the work for the Merge functions. The origins of the values are tracked
+// if names are given.
+func merge(inputs [][]byte, names []string, opts MergeOptions) (*bytes.Buffer, map[string]Origin, error) {
+	var (
+		merged any
+		prov   *provenance
+	)
+
+	if names != nil {
+		prov = &provenance{next: make(map[string]Origin)}
+	}
 
 	hasContent := false
 
 	for idx, data := range inputs {
+		data = fillEmptyDeleteTags(data)
+
 		dec := yaml.NewDecoder(bytes.NewReader(data), yaml.UseOrderedMap(), yaml.Strict())
 
 		var value any
@@ -33,56 +63,163 @@ func Merge(inputs [][]byte) (*bytes.Buffer, error) {
 				continue
 			}
 
-			return nil, fmt.Errorf("decoding document %d: %s", idx, yaml.FormatError(err, false, false))
+			return nil, nil, fmt.Errorf("decoding document %d: %s", idx, yaml.FormatError(err, false, false))
 		}
 
 		hasContent = true
 
-		mergedValue, err := mergeValue(merged, value)
+		m, err := newMerger(data, opts, prov)
 		if err != nil {
-			return nil, err
+			return nil, nil, fmt.Errorf("decoding document %d: %s", idx, yaml.FormatError(err, false, false))
+		}
+
+		if prov != nil {
+			prov.start(names[idx])
+		}
+
+		mergedValue, err := m.mergeValue(location{}, merged, value)
+		if err != nil {
+			return nil, nil, err
 		}
 
 		merged = mergedValue
 	}
 
+	var origins map[string]Origin
+
+	if prov != nil {
+		origins = prov.next
+		// the document itself
+		delete(origins, "")
+	}
+
 	buf := &bytes.Buffer{}
 	if merged == nil && !hasContent {
-		return buf, nil
+		return buf, origins, nil
 	}
 
 	enc := yaml.NewEncoder(buf)
 	if err := enc.Encode(merged); err != nil {
-		return nil, fmt.Errorf("encoding merged YAML: %w", err)
+		return nil, nil, fmt.Errorf("encoding merged YAML: %w", err)
+	}
+
+	return buf, origins, nil
+}
+
+// location is the path of a value in the document being merged, in the result
+// of the previous documents, and in the new result. They differ for sequence items.
+type location struct {
+	from docPath
+	into docPath
+	out  docPath
+}
+
+func (l location) key(key any) location {
+	seg := keySegment(key)
+
+	return location{
+		from: l.from.with(seg),
+		into: l.into.with(seg),
+		out:  l.out.with(seg),
+	}
+}
+
+// merger merges a document into the result of the previous ones.
+type merger struct {
+	strategies map[string]MergeStrategy
+	// local tags of the document, by path
+	tags map[string]string
+	// nil if the origins are not tracked
+	prov *provenance
+}
+
+func newMerger(data []byte, opts MergeOptions, prov *provenance) (*merger, error) {
+	m := &merger{
+		strategies: opts.Strategies,
+		tags:       make(map[string]string),
+		prov:       prov,
+	}
+
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(file.Docs) > 0 {
+		collectTags(file.Docs[0], m.tags)
+	}
+
+	if prov != nil {
+		prov.positions = make(map[string]Origin)
+
+		if len(file.Docs) > 0 {
+			collectPositions(file.Docs[0], prov.positions)
+		}
+	}
+
+	return m, nil
+}
+
+// strategy returns the strategy for the sequence at the given path, and
+// whether it was set by a tag.
+func (m *merger) strategy(path docPath) (MergeStrategy, bool, error) {
+	if tag, ok := m.tags[path.String()]; ok {
+		strategy, ok, err := parseStrategyTag(tag)
+		if err != nil {
+			return MergeStrategy{}, false, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if ok {
+			return strategy, true, nil
+		}
 	}
 
-	return buf, nil
+	return m.strategies[path.keyPath()], false, nil
 }
 
 // mergeValue merges from+into in strict mode.
-func mergeValue(into, from any) (any, error) {
+func (m *merger) mergeValue(loc location, into, from any) (any, error) {
+	strategy, tagged, err := m.strategy(loc.from)
+	if err != nil {
+		return nil, err
+	}
+
+	if tagged && !isSequence(from) {
+		return nil, fmt.Errorf("%s: %s can only be used on a sequence, not a %s", loc.from, strategy, describe(from))
+	}
+
 	if into == nil {
+		if err := m.checkNoDeletes(loc.from); err != nil {
+			return nil, err
+		}
+
+		m.prov.take(loc.from, loc.out)
+
 		return from, nil
 	}
 
 	if from == nil {
+		m.prov.take(loc.from, loc.out)
 		return nil, nil
 	}
 
 	// Scalars: override
 	if !isMapping(into) && !isSequence(into) && !isMapping(from) && !isSequence(from) {
+		m.prov.take(loc.from, loc.out)
 		return from, nil
 	}
 
-	// Sequences: replace
-	if isSequence(into) && isSequence(from) {
-		return from, nil
+	// Sequences: replace by default
+	if si, ok := into.([]any); ok {
+		if sf, ok2 := from.([]any); ok2 {
+			return m.mergeSequence(loc, si, sf, strategy)
+		}
 	}
 
 	// Mappings: deep-merge
 	if mi, ok := into.(yaml.MapSlice); ok {
 		if mf, ok2 := from.(yaml.MapSlice); ok2 {
-			return mergeMap(mi, mf)
+			return m.mergeMap(loc, mi, mf)
 		}
 	}
 
@@ -91,11 +228,35 @@ func mergeValue(into, from any) (any, error) {
 }
 
 // mergeMap deep-merges two ordered maps (MapSlice) in strict mode.
-func mergeMap(into, from yaml.MapSlice) (yaml.MapSlice, error) {
+func (m *merger) mergeMap(loc location, into, from yaml.MapSlice) (yaml.MapSlice, error) {
 	out := make(yaml.MapSlice, len(into))
 	copy(out, into)
 
+	m.prov.keepNode(loc.into, loc.out)
+
+	// the values that are not in the patch keep their origin
+	kept := make([]bool, len(out))
+	for i := range kept {
+		kept[i] = true
+	}
+
 	for _, item := range from {
+		itemLoc := loc.key(item.Key)
+
+		if m.isDeleted(itemLoc.from) {
+			idx := slices.IndexFunc(out, func(existing yaml.MapItem) bool {
+				return reflect.DeepEqual(existing.Key, item.Key)
+			})
+			if idx < 0 {
+				return nil, fmt.Errorf("%s: can't delete, key not found", itemLoc.from)
+			}
+
+			out = slices.Delete(out, idx, idx+1)
+			kept = slices.Delete(kept, idx, idx+1)
+
+			continue
+		}
+
 		matched := false
 
 		for i, existing := range out {
@@ -103,17 +264,234 @@ func mergeMap(into, from yaml.MapSlice) (yaml.MapSlice, error) {
 				continue
 			}
 
-			mergedVal, err := mergeValue(existing.Value, item.Value)
+			mergedVal, err := m.mergeValue(itemLoc, existing.Value, item.Value)
 			if err != nil {
 				return nil, err
 			}
 
 			out[i].Value = mergedVal
+			kept[i] = false
+			matched = true
+		}
+
+		if !matched {
+			// still check the tags of the new value
+			value, err := m.mergeValue(itemLoc, nil, item.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, yaml.MapItem{Key: item.Key, Value: value})
+			kept = append(kept, false)
+		}
+	}
+
+	for i, item := range out {
+		if kept[i] {
+			itemLoc := loc.key(item.Key)
+			m.prov.keep(itemLoc.into, itemLoc.out)
+		}
+	}
+
+	return out, nil
+}
+
+// seqItem is an item of a merged sequence, with its index in the previous result
+// and in the patch (-1 if not there).
+type seqItem struct {
+	value  any
+	into   int
+	from   int
+	merged bool
+}
+
+// mergeSequence combines two sequences according to the strategy.
+func (m *merger) mergeSequence(loc location, into, from []any, strategy MergeStrategy) ([]any, error) {
+	var (
+		items []seqItem
+		err   error
+	)
+
+	switch strategy.kind {
+	case appendItems, prependItems:
+		items, err = m.addItems(loc, into, from, strategy)
+	case mergeItemsByKey:
+		items, err = m.mergeByKey(loc, into, from, strategy.key)
+	default:
+		for j := range from {
+			if itemPath := loc.from.with(indexSegment(j)); m.isDeleted(itemPath) {
+				return nil, fmt.Errorf("%s: can't delete an item of a replaced sequence, use !append, !prepend or !merge:<field>", itemPath)
+			}
+		}
+
+		// nothing to delete from
+		if err := m.checkNoDeletes(loc.from); err != nil {
+			return nil, err
+		}
+
+		m.prov.take(loc.from, loc.out)
+
+		return from, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.prov.keepNode(loc.into, loc.out)
+
+	out := make([]any, len(items))
+
+	for k, item := range items {
+		out[k] = item.value
+
+		switch {
+		case item.merged:
+			// done by mergeValue()
+		case item.into >= 0:
+			m.prov.keep(loc.into.with(indexSegment(item.into)), loc.out.with(indexSegment(k)))
+		default:
+			m.prov.take(loc.from.with(indexSegment(item.from)), loc.out.with(indexSegment(k)))
+		}
+	}
+
+	return out, nil
+}
+
+// intoItems returns the items of the previous result.
+func intoItems(into []any) []seqItem {
+	items := make([]seqItem, len(into))
+	for i, value := range into {
+		items[i] = seqItem{value: value, into: i, from: -1}
+	}
+
+	return items
+}
+
+// addItems appends or prepends the items of a patch to a sequence. The items
+// tagged with !delete are removed instead.
+func (m *merger) addItems(loc location, into, from []any, strategy MergeStrategy) ([]seqItem, error) {
+	out := intoItems(into)
+	added := make([]seqItem, 0, len(from))
+
+	for j, item := range from {
+		itemPath := loc.from.with(indexSegment(j))
+
+		if m.isDeleted(itemPath) {
+			var err error
+
+			if out, err = deleteItem(itemPath, out, item, sameItem); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if err := m.checkNoDeletes(itemPath); err != nil {
+			return nil, err
+		}
+
+		added = append(added, seqItem{value: item, into: -1, from: j})
+	}
+
+	if strategy.kind == prependItems {
+		return append(added, out...), nil
+	}
+
+	return append(out, added...), nil
+}
+
+// itemKey returns the value of a field of a sequence item, if it's a mapping that has it.
+func itemKey(item any, field string) (any, bool) {
+	mapping, ok := item.(yaml.MapSlice)
+	if !ok {
+		return nil, false
+	}
+
+	for _, kv := range mapping {
+		if fmt.Sprint(kv.Key) == field {
+			return kv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// mergeByKey deep-merges the items of two sequences that have the same value
+// for a field, and appends the others.
+func (m *merger) mergeByKey(loc location, into, from []any, field string) ([]seqItem, error) {
+	out := intoItems(into)
+
+	keys := make([]any, len(from))
+
+	for j, item := range from {
+		key, ok := itemKey(item, field)
+		if !ok {
+			return nil, fmt.Errorf("%s: can't merge by %q, item %d has no such field", loc.from, field, j)
+		}
+
+		keys[j] = key
+	}
+
+	// delete first, so that the position of the other items is known when they are merged
+
+	for j, item := range from {
+		itemPath := loc.from.with(indexSegment(j))
+		if !m.isDeleted(itemPath) {
+			continue
+		}
+
+		var err error
+
+		out, err = deleteItem(itemPath, out, item, func(existing, _ any) bool {
+			existingKey, ok := itemKey(existing, field)
+			return ok && sameItem(existingKey, keys[j])
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for j, item := range from {
+		if m.isDeleted(loc.from.with(indexSegment(j))) {
+			continue
+		}
+
+		matched := false
+
+		for i, existing := range out {
+			if existingKey, ok := itemKey(existing.value, field); !ok || !reflect.DeepEqual(existingKey, keys[j]) {
+				continue
+			}
+
+			itemLoc := location{
+				from: loc.from.with(indexSegment(j)),
+				into: loc.into.with(indexSegment(existing.into)),
+				out:  loc.out.with(indexSegment(i)),
+			}
+
+			if existing.into < 0 {
+				// added by this patch
+				itemLoc.into = nil
+			}
+
+			mergedItem, err := m.mergeValue(itemLoc, existing.value, item)
+			if err != nil {
+				return nil, err
+			}
+
+			out[i] = seqItem{value: mergedItem, into: existing.into, from: j, merged: true}
 			matched = true
+
+			break
 		}
 
 		if !matched {
-			out = append(out, yaml.MapItem{Key: item.Key, Value: item.Value})
+			if err := m.checkNoDeletes(loc.from.with(indexSegment(j))); err != nil {
+				return nil, err
+			}
+
+			out = append(out, seqItem{value: item, into: -1, from: j})
 		}
 	}
 
@@ -208,3 +208,204 @@ func TestOrderPreservation(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, expect, merged.String())
 }
+
+func TestMergeStrategies(t *testing.T) {
+	base := `
+filters:
+  whitelist:
+    - 10.0.0.0/8
+    - 192.168.0.0/16
+sources:
+  - name: nginx
+    labels:
+      type: nginx
+    paths: [/var/log/nginx.log]
+  - name: ssh
+    labels:
+      type: syslog
+`
+
+	tests := []struct {
+		name       string
+		patch      string
+		strategies map[string]csyaml.MergeStrategy
+		want       string
+		wantErr    string
+	}{
+		{
+			name:  "replace by default",
+			patch: "filters:\n  whitelist: [1.2.3.4]\n",
+			want:  "filters:\n  whitelist:\n  - 1.2.3.4\n",
+		},
+		{
+			name:       "append by option",
+			patch:      "filters:\n  whitelist: [1.2.3.4]\n",
+			strategies: map[string]csyaml.MergeStrategy{"filters.whitelist": csyaml.Append},
+			want:       "filters:\n  whitelist:\n  - 10.0.0.0/8\n  - 192.168.0.0/16\n  - 1.2.3.4\n",
+		},
+		{
+			name:  "append by tag",
+			patch: "filters:\n  whitelist: !append [1.2.3.4]\n",
+			want:  "filters:\n  whitelist:\n  - 10.0.0.0/8\n  - 192.168.0.0/16\n  - 1.2.3.4\n",
+		},
+		{
+			name:  "prepend by tag",
+			patch: "filters:\n  whitelist: !prepend\n    - 1.2.3.4\n",
+			want:  "filters:\n  whitelist:\n  - 1.2.3.4\n  - 10.0.0.0/8\n  - 192.168.0.0/16\n",
+		},
+		{
+			name:       "tag overrides option",
+			patch:      "filters:\n  whitelist: !replace [1.2.3.4]\n",
+			strategies: map[string]csyaml.MergeStrategy{"filters.whitelist": csyaml.Append},
+			want:       "filters:\n  whitelist:\n  - 1.2.3.4\n",
+		},
+		{
+			name: "merge by key",
+			patch: `
+sources: !merge:name
+  - name: ssh
+    labels:
+      program: sshd
+  - name: apache
+`,
+			want: `sources:
+- name: nginx
+  labels:
+    type: nginx
+  paths:
+  - /var/log/nginx.log
+- name: ssh
+  labels:
+    type: syslog
+    program: sshd
+- name: apache
+`,
+		},
+		{
+			name: "nested strategies in items merged by key",
+			patch: `
+sources:
+  - name: nginx
+    paths: [/var/log/nginx2.log]
+`,
+			strategies: map[string]csyaml.MergeStrategy{
+				"sources":       csyaml.MergeByKey("name"),
+				"sources.paths": csyaml.Append,
+			},
+			want: `sources:
+- name: nginx
+  labels:
+    type: nginx
+  paths:
+  - /var/log/nginx.log
+  - /var/log/nginx2.log
+- name: ssh
+  labels:
+    type: syslog
+`,
+		},
+		{
+			name:    "merge by key, item without the field",
+			patch:   "sources: !merge:name\n  - labels: {}\n",
+			wantErr: `sources: can't merge by "name", item 0 has no such field`,
+		},
+		{
+			name:    "merge tag without field",
+			patch:   "sources: !merge\n  - name: ssh\n",
+			wantErr: "sources: missing field name in tag !merge, use !merge:<field>",
+		},
+		{
+			name:    "strategy tag on a mapping",
+			patch:   "filters: !append\n  whitelist: []\n",
+			wantErr: "filters: !append can only be used on a sequence, not a mapping",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := csyaml.MergeWithOptions([][]byte{[]byte(base), []byte(tc.patch)}, csyaml.MergeOptions{Strategies: tc.strategies})
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			// the other top-level key is unchanged
+			assert.Contains(t, buf.String(), tc.want)
+		})
+	}
+}
+
+func TestMergeDelete(t *testing.T) {
+	base := `
+a: 1
+b:
+  c: 2
+  d: 3
+list: [x, y, 8080]
+sources:
+  - name: nginx
+  - name: ssh
+`
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "delete keys",
+			patch: "a: !delete\nb:\n  c: !delete   # not needed\n",
+			want:  "b:\n  d: 3\nlist:\n- x\n- \"y\"\n- 8080\nsources:\n- name: nginx\n- name: ssh\n",
+		},
+		{
+			name:  "delete with a value",
+			patch: "b: !delete {}\nlist: !delete ~\nsources: !delete",
+			want:  "a: 1\n",
+		},
+		{
+			name:  "delete items by value",
+			patch: "list: !append\n  - !delete y\n  - !delete 8080\n  - z\n",
+			want:  "a: 1\nb:\n  c: 2\n  d: 3\nlist:\n- x\n- z\nsources:\n- name: nginx\n- name: ssh\n",
+		},
+		{
+			name:  "delete items by key",
+			patch: "sources: !merge:name\n  - !delete\n    name: nginx\n  - name: apache\n",
+			want:  "a: 1\nb:\n  c: 2\n  d: 3\nlist:\n- x\n- \"y\"\n- 8080\nsources:\n- name: ssh\n- name: apache\n",
+		},
+		{
+			name:    "missing key",
+			patch:   "b:\n  e: !delete\n",
+			wantErr: "b.e: can't delete, key not found",
+		},
+		{
+			name:    "missing parent",
+			patch:   "e:\n  f: !delete\n",
+			wantErr: "e.f: can't delete, key not found",
+		},
+		{
+			name:    "missing item",
+			patch:   "list: !append [!delete z]\n",
+			wantErr: "list[0]: can't delete, no matching item",
+		},
+		{
+			name:    "item in replaced sequence",
+			patch:   "list: [y, !delete x]\n",
+			wantErr: "list[1]: can't delete an item of a replaced sequence, use !append, !prepend or !merge:<field>",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := csyaml.Merge([][]byte{[]byte(base), []byte(tc.patch)})
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/goccy/go-yaml"
 	"github.com/sirupsen/logrus"
@@ -14,13 +16,19 @@ import (
 type Patcher struct {
 	BaseFilePath  string
 	PatchFilePath string
-	quiet         bool
+	// DropInDir contains fragments (*.yaml) merged after the base file, like
+	// config.yaml.d for config.yaml. It's optional.
+	DropInDir    string
+	quiet        bool
+	mergeOptions MergeOptions
+	expand       *ExpandOptions
 }
 
 func NewPatcher(filePath string, suffix string) *Patcher {
 	return &Patcher{
 		BaseFilePath:  filePath,
 		PatchFilePath: filePath + suffix,
+		DropInDir:     filePath + ".d",
 		quiet:         false,
 	}
 }
@@ -30,6 +38,17 @@ func (p *Patcher) SetQuiet(quiet bool) {
 	p.quiet = quiet
 }
 
+// SetMergeOptions sets the strategies used by MergedPatchContent for sequences.
+func (p *Patcher) SetMergeOptions(opts MergeOptions) {
+	p.mergeOptions = opts
+}
+
+// SetExpandEnv makes MergedPatchContent expand the environment variables in
+// the values of the merged document (see ExpandEnv).
+func (p *Patcher) SetExpandEnv(opts ExpandOptions) {
+	p.expand = &opts
+}
+
 // read a single YAML file, check for errors (the merge package doesn't) then return the content as bytes.
 func readYAML(filePath string) ([]byte, error) {
 	content, err := os.ReadFile(filePath)
@@ -46,38 +65,139 @@ func readYAML(filePath string) ([]byte, error) {
 	return content, nil
 }
 
-// MergedPatchContent reads a YAML file and, if it exists, its patch file,
-// then merges them and returns it serialized.
+// dropInFiles returns the fragments of the drop-in directory, in lexical order.
+func (p *Patcher) dropInFiles() ([]string, error) {
+	if p.DropInDir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(p.DropInDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("while reading drop-in directory: %w", err)
+	}
+
+	var files []string
+
+	// ReadDir sorts by file name
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".yaml" {
+			continue
+		}
+
+		files = append(files, filepath.Join(p.DropInDir, name))
+	}
+
+	return files, nil
+}
+
+// MergedPatchContent reads a YAML file and, if they exist, the fragments of
+// its drop-in directory and its patch file, then merges them in this order
+// and returns it serialized.
 func (p *Patcher) MergedPatchContent() ([]byte, error) {
+	content, _, _, err := p.mergedPatchContent(false)
+	return content, err
+}
+
+// MergedPatchContentWithFiles is like MergedPatchContent, and also returns
+// the files that have been merged, starting with the base file.
+func (p *Patcher) MergedPatchContentWithFiles() ([]byte, []string, error) {
+	content, files, _, err := p.mergedPatchContent(false)
+	return content, files, err
+}
+
+// MergedPatchContentWithProvenance is like MergedPatchContent, and also returns
+// the file, line and column where each value was set, by path.
+func (p *Patcher) MergedPatchContentWithProvenance() ([]byte, map[string]Origin, error) {
+	content, _, origins, err := p.mergedPatchContent(true)
+	return content, origins, err
+}
+
+func (p *Patcher) mergedPatchContent(withProvenance bool) ([]byte, []string, map[string]Origin, error) {
 	base, err := readYAML(p.BaseFilePath)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
-	over, err := readYAML(p.PatchFilePath)
-	if errors.Is(err, os.ErrNotExist) {
-		return base, nil
-	}
+	inputs := [][]byte{base}
+	files := []string{p.BaseFilePath}
 
+	patchFiles, err := p.dropInFiles()
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
-	logf := logrus.Infof
-	if p.quiet {
-		logf = logrus.Debugf
+	patchFiles = append(patchFiles, p.PatchFilePath)
+
+	for _, path := range patchFiles {
+		over, err := readYAML(path)
+
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case err != nil:
+			return nil, nil, nil, err
+		}
+
+		inputs = append(inputs, over)
+		files = append(files, path)
+	}
+
+	if len(inputs) == 1 && !withProvenance {
+		content, err := p.expandEnv(base)
+		return content, files, nil, err
+	}
+
+	if len(inputs) > 1 {
+		logf := logrus.Infof
+		if p.quiet {
+			logf = logrus.Debugf
+		}
+
+		logf("Loading yaml file: '%s' with additional values from '%s'", p.BaseFilePath, strings.Join(files[1:], "', '"))
 	}
 
-	logf("Loading yaml file: '%s' with additional values from '%s'", p.BaseFilePath, p.PatchFilePath)
+	var names []string
+	if withProvenance {
+		names = files
+	}
 
 	// strict mode true, will raise errors for duplicate map keys and
 	// overriding with a different type
-	patched, err := Merge([][]byte{base, over})
+	patched, origins, err := merge(inputs, names, p.mergeOptions)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+
+	content := patched.Bytes()
+	if len(inputs) == 1 {
+		// no patch, keep the file as it is
+		content = base
+	}
+
+	content, err = p.expandEnv(content)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return content, files, origins, nil
+}
+
+func (p *Patcher) expandEnv(content []byte) ([]byte, error) {
+	if p.expand == nil {
+		return content, nil
 	}
 
-	return patched.Bytes(), nil
+	content, err := ExpandEnv(content, *p.expand)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.BaseFilePath, err)
+	}
+
+	return content, nil
 }
 
 // read multiple YAML documents inside a file, and writes them to a buffer
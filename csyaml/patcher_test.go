@@ -161,6 +161,20 @@ func TestMergedPatchContent(t *testing.T) {
 			"",
 			"can't merge a mapping into a scalar",
 		},
+		{
+			"delete a key",
+			"{'first':{'one':1,'two':2},'second':{'three':3}}",
+			"first:\n  one: !delete\nsecond: !delete",
+			"{'first':{'two':2}}",
+			"",
+		},
+		{
+			"can't delete a missing key",
+			"{'first':{'one':1}}",
+			"first:\n  two: !delete",
+			"",
+			"first.two: can't delete, key not found",
+		},
 	}
 
 	for _, tc := range tests {
@@ -286,3 +300,76 @@ func TestPrependedPatchContent(t *testing.T) {
 		})
 	}
 }
+
+func TestMergedPatchContentStrategies(t *testing.T) {
+	dirPath := t.TempDir()
+
+	configPath := filepath.Join(dirPath, "config.yaml")
+	patchPath := filepath.Join(dirPath, "config.yaml.local")
+
+	err := os.WriteFile(configPath, []byte("labels: [a, b]\ncidrs: [10.0.0.0/8]\n"), 0o600)
+	require.NoError(t, err)
+
+	err = os.WriteFile(patchPath, []byte("labels: !prepend [c]\ncidrs: [1.2.3.4/32]\n"), 0o600)
+	require.NoError(t, err)
+
+	patcher := csyaml.NewPatcher(configPath, ".local")
+	patcher.SetMergeOptions(csyaml.MergeOptions{
+		Strategies: map[string]csyaml.MergeStrategy{"cidrs": csyaml.Append},
+	})
+
+	patchedBytes, err := patcher.MergedPatchContent()
+	require.NoError(t, err)
+	require.YAMLEq(t, "labels: [c, a, b]\ncidrs: [10.0.0.0/8, 1.2.3.4/32]\n", string(patchedBytes))
+}
+
+func TestMergedPatchContentDropIn(t *testing.T) {
+	dirPath := t.TempDir()
+
+	configPath := filepath.Join(dirPath, "config.yaml")
+	patchPath := filepath.Join(dirPath, "config.yaml.local")
+	dropInDir := filepath.Join(dirPath, "config.yaml.d")
+
+	err := os.WriteFile(configPath, []byte("one: 1\ntwo: 2\nthree: 3\n"), 0o600)
+	require.NoError(t, err)
+
+	patcher := csyaml.NewPatcher(configPath, ".local")
+
+	// no drop-in directory
+	content, files, err := patcher.MergedPatchContentWithFiles()
+	require.NoError(t, err)
+	require.Equal(t, "one: 1\ntwo: 2\nthree: 3\n", string(content))
+	require.Equal(t, []string{configPath}, files)
+
+	err = os.Mkdir(dropInDir, 0o700)
+	require.NoError(t, err)
+
+	for name, fragment := range map[string]string{
+		"20-second.yaml": "two: 20\nthree: 30\n",
+		"10-first.yaml":  "two: 10\n",
+		"30-ignored.yml": "one: 100\n",
+		".hidden.yaml":   "one: 100\n",
+	} {
+		err = os.WriteFile(filepath.Join(dropInDir, name), []byte(fragment), 0o600)
+		require.NoError(t, err)
+	}
+
+	err = os.WriteFile(patchPath, []byte("three: 300\n"), 0o600)
+	require.NoError(t, err)
+
+	content, files, err = patcher.MergedPatchContentWithFiles()
+	require.NoError(t, err)
+	require.Equal(t, "one: 1\ntwo: 20\nthree: 300\n", string(content))
+	require.Equal(t, []string{
+		configPath,
+		filepath.Join(dropInDir, "10-first.yaml"),
+		filepath.Join(dropInDir, "20-second.yaml"),
+		patchPath,
+	}, files)
+
+	err = os.WriteFile(filepath.Join(dropInDir, "40-invalid.yaml"), []byte("notayaml"), 0o600)
+	require.NoError(t, err)
+
+	_, err = patcher.MergedPatchContent()
+	cstest.RequireErrorContains(t, err, "40-invalid.yaml: [1:1] string was used where mapping is expected")
+}
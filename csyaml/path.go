@@ -0,0 +1,95 @@
+package csyaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is a mapping key or a sequence index in a document path.
+type pathSegment struct {
+	key   string
+	index int
+	isKey bool
+}
+
+func keySegment(key any) pathSegment {
+	return pathSegment{key: fmt.Sprint(key), isKey: true}
+}
+
+func indexSegment(index int) pathSegment {
+	return pathSegment{index: index}
+}
+
+// docPath is the location of a node, from the root of a document.
+type docPath []pathSegment
+
+// with returns a new path with a segment appended, without modifying p.
+func (p docPath) with(seg pathSegment) docPath {
+	ret := make(docPath, len(p), len(p)+1)
+	copy(ret, p)
+
+	return append(ret, seg)
+}
+
+// quoteKey quotes the keys that can't be written as they are in a path.
+func quoteKey(key string) string {
+	if key == "" || strings.ContainsAny(key, `.[]"' `) {
+		return strconv.Quote(key)
+	}
+
+	return key
+}
+
+// String returns the path with dots between keys and brackets around indexes,
+// like `api.server.listen_uri` or `sources[0]."label.name"`.
+func (p docPath) String() string {
+	var sb strings.Builder
+
+	for i, seg := range p {
+		if !seg.isKey {
+			sb.WriteString("[" + strconv.Itoa(seg.index) + "]")
+			continue
+		}
+
+		if i > 0 {
+			sb.WriteString(".")
+		}
+
+		sb.WriteString(quoteKey(seg.key))
+	}
+
+	return sb.String()
+}
+
+// keyPath returns the path without the sequence indexes, as used in MergeOptions.
+func (p docPath) keyPath() string {
+	keys := make([]string, 0, len(p))
+
+	for _, seg := range p {
+		if seg.isKey {
+			keys = append(keys, quoteKey(seg.key))
+		}
+	}
+
+	return strings.Join(keys, ".")
+}
+
+// cutPathPrefix returns the rest of a path after a prefix, which must end
+// before a key or an index.
+func cutPathPrefix(path, prefix string) (string, bool) {
+	if prefix == "" {
+		return path, true
+	}
+
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return "", false
+	}
+
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		return "", false
+	}
+
+	return rest, true
+}
@@ -0,0 +1,429 @@
+package csyaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// MergePreservingFormat is like MergeWithOptions, but it changes the first
+// document in place instead of encoding the result again: its comments, quoting
+// style, anchors and blank lines are kept. The comments of the values that come
+// from a patch are carried over.
+//
+// The result is checked against MergeWithOptions; an error is returned if the
+// edited document doesn't have the same content, for example when a patch
+// overrides a value used by an alias.
+func MergePreservingFormat(inputs [][]byte, opts MergeOptions) (*bytes.Buffer, error) {
+	expected, err := MergeWithOptions(inputs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *ast.DocumentNode
+
+	for idx, data := range inputs {
+		data = fillEmptyDeleteTags(data)
+
+		file, err := parser.ParseBytes(data, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("decoding document %d: %s", idx, yaml.FormatError(err, false, false))
+		}
+
+		if len(file.Docs) == 0 || file.Docs[0].Body == nil {
+			continue
+		}
+
+		doc := file.Docs[0]
+
+		if result == nil {
+			doc.Body = stripTags(doc.Body)
+			result = doc
+
+			continue
+		}
+
+		m, err := newMerger(data, opts, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decoding document %d: %s", idx, yaml.FormatError(err, false, false))
+		}
+
+		if result.Body, err = m.mergeNode(nil, result.Body, doc.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if result == nil {
+		return buf, nil
+	}
+
+	buf.WriteString(result.String())
+
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteString("\n")
+	}
+
+	if err := sameContent(buf.Bytes(), expected.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// sameContent returns an error if two documents don't decode to the same value.
+func sameContent(got, expected []byte) error {
+	var gotValue, expectedValue any
+
+	if err := yaml.UnmarshalWithOptions(got, &gotValue, yaml.UseOrderedMap()); err != nil {
+		return fmt.Errorf("can't preserve the format of the merged document: %s", yaml.FormatError(err, false, false))
+	}
+
+	if err := yaml.UnmarshalWithOptions(expected, &expectedValue, yaml.UseOrderedMap()); err != nil {
+		return fmt.Errorf("decoding merged YAML: %s", yaml.FormatError(err, false, false))
+	}
+
+	if !reflect.DeepEqual(gotValue, expectedValue) {
+		return errors.New("can't preserve the format of the merged document: the content differs from a plain merge")
+	}
+
+	return nil
+}
+
+// stripTags removes our strategy tags from a node and its children, since
+// they don't belong to the merged document.
+func stripTags(node ast.Node) ast.Node {
+	switch n := node.(type) {
+	case *ast.TagNode:
+		if _, ok, _ := parseStrategyTag(n.Start.Value); ok {
+			return stripTags(n.Value)
+		}
+
+		n.Value = stripTags(n.Value)
+	case *ast.AnchorNode:
+		n.Value = stripTags(n.Value)
+	case *ast.MappingNode:
+		for _, value := range n.Values {
+			value.Value = stripTags(value.Value)
+		}
+	case *ast.SequenceNode:
+		for i, value := range n.Values {
+			n.Values[i] = stripTags(value)
+		}
+	}
+
+	return node
+}
+
+// unwrapNode returns the node under the anchors and tags.
+func unwrapNode(node ast.Node) ast.Node {
+	for {
+		switch n := node.(type) {
+		case *ast.AnchorNode:
+			node = n.Value
+		case *ast.TagNode:
+			node = n.Value
+		default:
+			return node
+		}
+	}
+}
+
+// nodeValue decodes a node, or returns nil if it can't be decoded on its own.
+func nodeValue(node ast.Node) any {
+	var value any
+
+	if err := yaml.NodeToValue(unwrapNode(node), &value, yaml.UseOrderedMap()); err != nil {
+		return nil
+	}
+
+	return value
+}
+
+// setFlowStyle makes a mapping or a sequence fit in a flow collection.
+func setFlowStyle(node ast.Node) {
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		n.SetIsFlowStyle(true)
+	case *ast.SequenceNode:
+		n.SetIsFlowStyle(true)
+	}
+}
+
+// mergeNode merges a node of a patch into the node of the previous documents.
+// Mappings and merged sequences are changed in place and returned; otherwise
+// the node of the patch replaces the previous one.
+func (m *merger) mergeNode(path docPath, into, from ast.Node) (ast.Node, error) {
+	strategy, _, err := m.strategy(path)
+	if err != nil {
+		return nil, err
+	}
+
+	from = stripTags(from)
+
+	switch f := from.(type) {
+	case *ast.MappingNode:
+		if target, ok := unwrapNode(into).(*ast.MappingNode); ok {
+			return into, m.mergeMappingNode(path, target, f)
+		}
+	case *ast.SequenceNode:
+		if target, ok := unwrapNode(into).(*ast.SequenceNode); ok && strategy.kind != replaceItems {
+			return into, m.mergeSequenceNode(path, target, f, strategy)
+		}
+	}
+
+	return from, nil
+}
+
+// mergeMappingNode deep-merges the keys of a patch into a mapping.
+func (m *merger) mergeMappingNode(path docPath, into, from *ast.MappingNode) error {
+	for _, item := range from.Values {
+		key := nodeKey(item.Key)
+		itemPath := path.with(keySegment(key))
+
+		idx := slices.IndexFunc(into.Values, func(existing *ast.MappingValueNode) bool {
+			return nodeKey(existing.Key) == key
+		})
+
+		if m.isDeleted(itemPath) {
+			if idx >= 0 {
+				into.Values = slices.Delete(into.Values, idx, idx+1)
+			}
+
+			continue
+		}
+
+		if idx < 0 {
+			item.Value = stripTags(item.Value)
+			addMappingValue(into, item)
+
+			continue
+		}
+
+		existing := into.Values[idx]
+
+		value, err := m.mergeNode(itemPath, existing.Value, item.Value)
+		if err != nil {
+			return err
+		}
+
+		if value != existing.Value && !sameScalar(existing.Value, value) {
+			replaceMappingValue(existing, item, value)
+		}
+
+		appendHeadComment(existing, item)
+
+		if flowIfEmpty(existing.Value) {
+			// the value goes on the line of the key
+			existing.Key.GetToken().Position.IndentLevel = existing.Value.GetToken().Position.IndentLevel
+		}
+	}
+
+	return nil
+}
+
+// flowIfEmpty writes a mapping or sequence whose items have all been deleted
+// as {} or [], since an empty block is not valid YAML.
+func flowIfEmpty(node ast.Node) bool {
+	switch n := unwrapNode(node).(type) {
+	case *ast.MappingNode:
+		if len(n.Values) == 0 {
+			n.SetIsFlowStyle(true)
+			return true
+		}
+	case *ast.SequenceNode:
+		if len(n.Values) == 0 {
+			n.SetIsFlowStyle(true)
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameScalar tells whether a scalar of a patch has the same value as the previous
+// one and no comment, so the previous one can be kept as it is.
+func sameScalar(into, from ast.Node) bool {
+	_, intoScalar := into.(ast.ScalarNode)
+	_, fromScalar := from.(ast.ScalarNode)
+
+	return intoScalar && fromScalar && from.GetComment() == nil && reflect.DeepEqual(nodeValue(into), nodeValue(from))
+}
+
+// addMappingValue appends a key of a patch to a mapping, at the indentation of
+// the other keys.
+func addMappingValue(into *ast.MappingNode, item *ast.MappingValueNode) {
+	switch {
+	case into.IsFlowStyle:
+		item.SetIsFlowStyle(true)
+	case len(into.Values) > 0:
+		item.AddColumn(into.Values[0].Key.GetToken().Position.Column - item.Key.GetToken().Position.Column)
+	}
+
+	into.Values = append(into.Values, item)
+}
+
+// replaceMappingValue sets the value of a key from a patch. The key is kept
+// with its position. A scalar keeps the comment after it if the patch has none.
+func replaceMappingValue(existing, item *ast.MappingValueNode, value ast.Node) {
+	keyToken := existing.Key.GetToken()
+	itemKeyToken := item.Key.GetToken()
+
+	value.AddColumn(keyToken.Position.Column - itemKeyToken.Position.Column)
+
+	if existing.IsFlowStyle {
+		setFlowStyle(value)
+	}
+
+	// tells whether the value goes on the next line
+	keyToken.Position.IndentLevel = itemKeyToken.Position.IndentLevel
+
+	_, oldScalar := existing.Value.(ast.ScalarNode)
+	_, newScalar := value.(ast.ScalarNode)
+
+	if oldScalar && newScalar && item.Comment == nil && value.GetComment() == nil && existing.Value.GetComment() != nil {
+		// a scalar node always takes a comment
+		_ = value.SetComment(existing.Value.GetComment())
+	}
+
+	existing.Value = value
+}
+
+// appendHeadComment adds the comments before a key of a patch after the ones
+// of the previous documents.
+func appendHeadComment(existing, item *ast.MappingValueNode) {
+	switch {
+	case item.Comment == nil:
+	case existing.Comment == nil:
+		existing.Comment = item.Comment
+	default:
+		existing.Comment.Comments = append(existing.Comment.Comments, item.Comment.Comments...)
+	}
+}
+
+// seqEntry is an item of a sequence node, with the comment before it.
+type seqEntry struct {
+	node    ast.Node
+	comment *ast.CommentGroupNode
+}
+
+func seqEntries(seq *ast.SequenceNode) []seqEntry {
+	entries := make([]seqEntry, len(seq.Values))
+
+	for i, value := range seq.Values {
+		entries[i].node = value
+		if len(seq.ValueHeadComments) == len(seq.Values) {
+			entries[i].comment = seq.ValueHeadComments[i]
+		}
+	}
+
+	return entries
+}
+
+// setEntries replaces the items of a sequence node.
+func setEntries(seq *ast.SequenceNode, entries []seqEntry) {
+	seq.Values = make([]ast.Node, len(entries))
+	seq.ValueHeadComments = nil
+
+	hasComments := false
+
+	for i, entry := range entries {
+		seq.Values[i] = entry.node
+		hasComments = hasComments || entry.comment != nil
+	}
+
+	if hasComments {
+		seq.ValueHeadComments = make([]*ast.CommentGroupNode, len(entries))
+		for i, entry := range entries {
+			seq.ValueHeadComments[i] = entry.comment
+		}
+	}
+}
+
+// mergeSequenceNode adds the items of a patch to a sequence, or merges them by key.
+// The items tagged with !delete are removed instead.
+func (m *merger) mergeSequenceNode(path docPath, into, from *ast.SequenceNode, strategy MergeStrategy) error {
+	entries := seqEntries(into)
+	patchEntries := seqEntries(from)
+
+	// delete first, like merge()
+	for j, entry := range patchEntries {
+		if !m.isDeleted(path.with(indexSegment(j))) {
+			continue
+		}
+
+		item := nodeValue(entry.node)
+
+		entries = slices.DeleteFunc(entries, func(existing seqEntry) bool {
+			if strategy.kind != mergeItemsByKey {
+				return sameItem(nodeValue(existing.node), item)
+			}
+
+			existingKey, ok := itemKey(nodeValue(existing.node), strategy.key)
+			key, _ := itemKey(item, strategy.key)
+
+			return ok && sameItem(existingKey, key)
+		})
+	}
+
+	added := make([]seqEntry, 0, len(patchEntries))
+
+	for j, entry := range patchEntries {
+		itemPath := path.with(indexSegment(j))
+		if m.isDeleted(itemPath) {
+			continue
+		}
+
+		if strategy.kind == mergeItemsByKey {
+			key, _ := itemKey(nodeValue(entry.node), strategy.key)
+
+			idx := slices.IndexFunc(entries, func(existing seqEntry) bool {
+				existingKey, ok := itemKey(nodeValue(existing.node), strategy.key)
+				return ok && reflect.DeepEqual(existingKey, key)
+			})
+
+			if idx >= 0 {
+				merged, err := m.mergeNode(itemPath, entries[idx].node, entry.node)
+				if err != nil {
+					return err
+				}
+
+				flowIfEmpty(merged)
+				entries[idx].node = merged
+
+				continue
+			}
+		}
+
+		entry.node = stripTags(entry.node)
+
+		if into.IsFlowStyle {
+			setFlowStyle(entry.node)
+		} else {
+			entry.node.AddColumn(into.Start.Position.Column - from.Start.Position.Column)
+		}
+
+		if strategy.kind == mergeItemsByKey {
+			// the next items of the patch can be merged into this one
+			entries = append(entries, entry)
+		} else {
+			added = append(added, entry)
+		}
+	}
+
+	if strategy.kind == prependItems {
+		entries = append(added, entries...)
+	} else {
+		entries = append(entries, added...)
+	}
+
+	setEntries(into, entries)
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package csyaml_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/csyaml"
+)
+
+func TestMergePreservingFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputs  []string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "single document is unchanged",
+			inputs: []string{"# header\na: 'quoted' # note\n\nb: &anchor 2\n"},
+			want:   "# header\na: 'quoted' # note\n\nb: &anchor 2\n",
+		},
+		{
+			name: "override keeps the comments of the base",
+			inputs: []string{
+				"# server settings\napi:\n  # where to listen\n  listen_uri: \"127.0.0.1:8080\" # default\n  enable: true\n\nlog_level: info\n",
+				"api:\n    listen_uri: 0.0.0.0:8080 # public\n",
+			},
+			want: "# server settings\napi:\n  # where to listen\n  listen_uri: 0.0.0.0:8080 # public\n  enable: true\n\nlog_level: info\n",
+		},
+		{
+			name: "override keeps the inline comment of the base",
+			inputs: []string{
+				"a: 1 # one\nb: 2\n",
+				"a: 2\n",
+			},
+			want: "a: 2 # one\nb: 2\n",
+		},
+		{
+			name: "comments of the base and the patch",
+			inputs: []string{
+				"# keep\na: 1\nb:\n  # nested\n  c: 2\n",
+				"# why a\na: 5\n# why b\nb:\n  # why c\n  c: 3\n",
+			},
+			want: "# keep\n# why a\na: 5\n# why b\nb:\n  # nested\n  # why c\n  c: 3\n",
+		},
+		{
+			name: "all the keys of a mapping deleted",
+			inputs: []string{
+				"top:\n  a:\n    b: 1\n  c: 2\n",
+				"top:\n  a:\n    b: !delete\n",
+			},
+			want: "top:\n  a: {}\n  c: 2\n",
+		},
+		{
+			name: "all the items of a sequence deleted",
+			inputs: []string{
+				"a:\n  - x\n  - y\nc: 2\n",
+				"a: !append\n  - !delete x\n  - !delete y\n",
+			},
+			want: "a: []\nc: 2\n",
+		},
+		{
+			name: "new keys are aligned with the base",
+			inputs: []string{
+				"api:\n  enable: true\n",
+				"api:\n    # added\n    tls:\n        cert: /etc/cert.pem\nnew: 1\n",
+			},
+			want: "api:\n  enable: true\n  # added\n  tls:\n      cert: /etc/cert.pem\nnew: 1\n",
+		},
+		{
+			name: "block value replaced by a flow value",
+			inputs: []string{
+				"list:\n- a\n- b\n\nother: 1\n",
+				"list: [c, d]\n",
+			},
+			want: "list: [c, d]\n\nother: 1\n",
+		},
+		{
+			name: "append to a sequence",
+			inputs: []string{
+				"list:\n  # first\n  - a\n  - b\n",
+				"list: !append\n- c\n",
+			},
+			want: "list:\n  # first\n  - a\n  - b\n  - c\n",
+		},
+		{
+			name: "prepend to a flow sequence",
+			inputs: []string{
+				"list: [a, b]\n",
+				"list: !prepend\n  - c\n",
+			},
+			want: "list: [c, a, b]\n",
+		},
+		{
+			name: "merge by key and delete",
+			inputs: []string{
+				"sources:\n  - name: nginx # web\n    type: file\n  - name: ssh\n    type: journal\ndebug: true\n",
+				"sources: !merge:name\n  - name: nginx\n    type: syslog\n  - !delete\n    name: ssh\n  - name: apache\ndebug: !delete\n",
+			},
+			want: "sources:\n  - name: nginx # web\n    type: syslog\n  - name: apache\n",
+		},
+		{
+			name: "strategy tags are removed from new values",
+			inputs: []string{
+				"a: 1\n",
+				"list: !append [x]\n",
+			},
+			want: "a: 1\nlist: [x]\n",
+		},
+		{
+			name: "empty base",
+			inputs: []string{
+				"# nothing here\n",
+				"a: 1 # one\n",
+			},
+			want: "a: 1 # one\n",
+		},
+		{
+			name:    "errors of the merge are returned",
+			inputs:  []string{"foo: 1\n", "foo:\n  - a\n"},
+			wantErr: "can't merge a sequence into a scalar",
+		},
+		{
+			name: "alias of an overridden value",
+			inputs: []string{
+				"a: &x 1\nb: *x\n",
+				"a: 2\n",
+			},
+			wantErr: "can't preserve the format of the merged document",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			inputs := make([][]byte, len(tc.inputs))
+			for i, s := range tc.inputs {
+				inputs[i] = []byte(s)
+			}
+
+			buf, err := csyaml.MergePreservingFormat(inputs, csyaml.MergeOptions{})
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			require.NotNil(t, buf)
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package csyaml
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/token"
+)
+
+// Origin is the place where a value of a merged document was set.
+type Origin struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (o Origin) String() string {
+	return fmt.Sprintf("%s:%d:%d", o.File, o.Line, o.Column)
+}
+
+// MergeWithProvenance is like MergeWithOptions, and also returns the origin of
+// each value of the result, by path (like `api.server.listen_uri` or `sources[0].name`).
+// The names are used as file names in the origins, one for each input.
+//
+// The origin of a mapping entry is the position of its key, and the origin of
+// a mapping or sequence is where it was first defined.
+func MergeWithProvenance(inputs [][]byte, names []string, opts MergeOptions) (*bytes.Buffer, map[string]Origin, error) {
+	if len(names) != len(inputs) {
+		return nil, nil, fmt.Errorf("%d names for %d inputs", len(names), len(inputs))
+	}
+
+	return merge(inputs, names, opts)
+}
+
+// provenance tracks the origins of the values while a document is merged.
+type provenance struct {
+	// file name of the current document
+	name string
+	// origins of the values in the result of the previous documents
+	prev map[string]Origin
+	// positions of the values in the current document
+	positions map[string]Origin
+	// origins of the values in the new result
+	next map[string]Origin
+}
+
+// start prepares the merge of a new document.
+func (p *provenance) start(name string) {
+	p.name = name
+	p.prev = p.next
+	p.next = make(map[string]Origin)
+
+	for path, origin := range p.positions {
+		origin.File = name
+		p.positions[path] = origin
+	}
+}
+
+// copySubtree copies the origins of a value and its children from one path to another.
+func copySubtree(dst map[string]Origin, dstPath docPath, src map[string]Origin, srcPath docPath) {
+	prefix := srcPath.String()
+	dstPrefix := dstPath.String()
+
+	for path, origin := range src {
+		if rest, ok := cutPathPrefix(path, prefix); ok {
+			dst[dstPrefix+rest] = origin
+		}
+	}
+}
+
+// keep is for values of the previous result that are not changed.
+func (p *provenance) keep(into, out docPath) {
+	if p == nil {
+		return
+	}
+
+	copySubtree(p.next, out, p.prev, into)
+}
+
+// keepNode is for mappings and sequences that are merged: the children
+// are handled separately.
+func (p *provenance) keepNode(into, out docPath) {
+	if p == nil {
+		return
+	}
+
+	if origin, ok := p.prev[into.String()]; ok {
+		p.next[out.String()] = origin
+	}
+}
+
+// take is for values of the current document that replace the previous ones.
+func (p *provenance) take(from, out docPath) {
+	if p == nil {
+		return
+	}
+
+	copySubtree(p.next, out, p.positions, from)
+}
+
+// collectPositions records the position of each value of a document.
+func collectPositions(doc ast.Node, positions map[string]Origin) {
+	walkNodes(doc, nil, nil, func(path docPath, _ ast.Node, pos *token.Token) {
+		if pos == nil {
+			return
+		}
+
+		positions[path.String()] = Origin{Line: pos.Position.Line, Column: pos.Position.Column}
+	})
+}
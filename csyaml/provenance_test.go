@@ -0,0 +1,102 @@
+package csyaml_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/csyaml"
+)
+
+func TestMergeWithProvenance(t *testing.T) {
+	base := `api:
+  server:
+    listen_uri: 127.0.0.1:8080
+    enable: true
+labels: [a, b]
+sources:
+  - name: nginx
+    type: file
+  - name: ssh
+    type: journal
+removed: 1
+`
+
+	patch := `api:
+  server:
+    listen_uri: 0.0.0.0:8080
+labels: !prepend
+  - c
+sources: !merge:name
+  - name: ssh
+    type: syslog
+  - name: apache
+removed: !delete
+`
+
+	_, origins, err := csyaml.MergeWithProvenance(
+		[][]byte{[]byte(base), []byte(patch)},
+		[]string{"config.yaml", "config.yaml.local"},
+		csyaml.MergeOptions{})
+	require.NoError(t, err)
+
+	expected := map[string]string{
+		"api":                   "config.yaml:1:1",
+		"api.server":            "config.yaml:2:3",
+		"api.server.listen_uri": "config.yaml.local:3:5",
+		"api.server.enable":     "config.yaml:4:5",
+		"labels":                "config.yaml:5:1",
+		"labels[0]":             "config.yaml.local:5:5",
+		"labels[1]":             "config.yaml:5:10",
+		"labels[2]":             "config.yaml:5:13",
+		"sources":               "config.yaml:6:1",
+		"sources[0]":            "config.yaml:7:5",
+		"sources[0].name":       "config.yaml:7:5",
+		"sources[0].type":       "config.yaml:8:5",
+		"sources[1]":            "config.yaml:9:5",
+		"sources[1].name":       "config.yaml.local:7:5",
+		"sources[1].type":       "config.yaml.local:8:5",
+		"sources[2]":            "config.yaml.local:9:5",
+		"sources[2].name":       "config.yaml.local:9:5",
+	}
+
+	got := make(map[string]string, len(origins))
+	for path, origin := range origins {
+		got[path] = origin.String()
+	}
+
+	assert.Equal(t, expected, got)
+
+	_, _, err = csyaml.MergeWithProvenance([][]byte{[]byte(base)}, nil, csyaml.MergeOptions{})
+	cstest.RequireErrorContains(t, err, "0 names for 1 inputs")
+}
+
+func TestMergedPatchContentWithProvenance(t *testing.T) {
+	dirPath := t.TempDir()
+
+	configPath := filepath.Join(dirPath, "config.yaml")
+	patchPath := filepath.Join(dirPath, "config.yaml.local")
+
+	err := os.WriteFile(configPath, []byte("one: 1\ntwo: 2\n"), 0o600)
+	require.NoError(t, err)
+
+	patcher := csyaml.NewPatcher(configPath, ".local")
+
+	content, origins, err := patcher.MergedPatchContentWithProvenance()
+	require.NoError(t, err)
+	assert.Equal(t, "one: 1\ntwo: 2\n", string(content))
+	assert.Equal(t, csyaml.Origin{File: configPath, Line: 2, Column: 1}, origins["two"])
+
+	err = os.WriteFile(patchPath, []byte("# override\ntwo: 20\n"), 0o600)
+	require.NoError(t, err)
+
+	content, origins, err = patcher.MergedPatchContentWithProvenance()
+	require.NoError(t, err)
+	assert.Equal(t, "one: 1\ntwo: 20\n", string(content))
+	assert.Equal(t, csyaml.Origin{File: configPath, Line: 1, Column: 1}, origins["one"])
+	assert.Equal(t, csyaml.Origin{File: patchPath, Line: 2, Column: 1}, origins["two"])
+}
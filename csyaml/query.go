@@ -0,0 +1,220 @@
+package csyaml
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+// ErrPathNotFound is returned by Get and Lookup when there is no value at a path.
+var ErrPathNotFound = errors.New("path not found")
+
+// Kind is the type of a YAML value.
+type Kind int
+
+const (
+	NullKind Kind = iota
+	ScalarKind
+	MappingKind
+	SequenceKind
+)
+
+func (k Kind) String() string {
+	switch k {
+	case ScalarKind:
+		return "scalar"
+	case MappingKind:
+		return "mapping"
+	case SequenceKind:
+		return "sequence"
+	default:
+		return "null"
+	}
+}
+
+func kindOf(value any) Kind {
+	switch {
+	case value == nil:
+		return NullKind
+	case isMapping(value):
+		return MappingKind
+	case isSequence(value):
+		return SequenceKind
+	default:
+		return ScalarKind
+	}
+}
+
+// parsePath parses a path written like docPath.String(): keys separated by
+// dots, indexes in brackets, and quotes around the keys that contain special
+// characters, like `sources[0]."label.name"`. An empty path is the whole document.
+func parsePath(s string) (docPath, error) {
+	var path docPath
+
+	rest := s
+
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: missing ]", s)
+			}
+
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", s, rest[1:end])
+			}
+
+			path = append(path, indexSegment(idx))
+			rest = rest[end+1:]
+		case rest[0] == '"':
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: unterminated quote", s)
+			}
+
+			key, _ := strconv.Unquote(quoted)
+			path = append(path, keySegment(key))
+			rest = rest[len(quoted):]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: empty key", s)
+			}
+
+			path = append(path, keySegment(rest[:end]))
+			rest = rest[end:]
+		}
+
+		if rest == "" || rest[0] == '[' {
+			continue
+		}
+
+		if rest[0] != '.' || len(rest) == 1 {
+			return nil, fmt.Errorf("invalid path %q: expected . or [ after %s", s, path)
+		}
+
+		rest = rest[1:]
+	}
+
+	return path, nil
+}
+
+// getValue returns the value at a path in a decoded document.
+func getValue(doc any, path docPath) (any, error) {
+	for i, seg := range path {
+		found := false
+
+		switch node := doc.(type) {
+		case yaml.MapSlice:
+			if !seg.isKey {
+				break
+			}
+
+			if idx := mapIndex(node, seg.key); idx >= 0 {
+				doc = node[idx].Value
+				found = true
+			}
+		case map[string]any:
+			if seg.isKey {
+				doc, found = node[seg.key]
+			}
+		case []any:
+			if !seg.isKey && seg.index < len(node) {
+				doc = node[seg.index]
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%s: %w", path[:i+1], ErrPathNotFound)
+		}
+	}
+
+	return doc, nil
+}
+
+// Get returns the value at a path, like `api.server.listen_uri` or
+// `sources[0]."label.name"`, in a decoded document. Mappings can be
+// yaml.MapSlice, as returned by Merge() once decoded, or map[string]any.
+func Get(doc any, path string) (any, error) {
+	p, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return getValue(doc, p)
+}
+
+// LookupResult is a value found by Lookup.
+type LookupResult struct {
+	// Node is the value in the syntax tree of the document.
+	Node ast.Node
+	// Value is the decoded value: yaml.MapSlice, []any, a scalar or nil.
+	Value any
+	Kind  Kind
+	// Line and Column locate the value, or its key in a mapping like in Origin.
+	Line   int
+	Column int
+}
+
+// Lookup finds the value at a path in the first document of a YAML input,
+// with its position.
+func Lookup(data []byte, path string) (LookupResult, error) {
+	p, err := parsePath(path)
+	if err != nil {
+		return LookupResult{}, err
+	}
+
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return LookupResult{}, errors.New(yaml.FormatError(err, false, false))
+	}
+
+	var result LookupResult
+
+	if len(file.Docs) > 0 {
+		want := p.String()
+
+		walkNodes(file.Docs[0], nil, nil, func(path docPath, node ast.Node, pos *token.Token) {
+			if result.Node != nil || path.String() != want {
+				return
+			}
+
+			result.Node = node
+
+			if pos != nil {
+				result.Line = pos.Position.Line
+				result.Column = pos.Position.Column
+			}
+		})
+	}
+
+	if result.Node == nil {
+		return LookupResult{}, fmt.Errorf("%s: %w", p, ErrPathNotFound)
+	}
+
+	doc, err := decodeValue(data)
+	if err != nil {
+		return LookupResult{}, err
+	}
+
+	if result.Value, err = getValue(doc, p); err != nil {
+		return LookupResult{}, err
+	}
+
+	result.Kind = kindOf(result.Value)
+
+	return result, nil
+}
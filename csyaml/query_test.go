@@ -0,0 +1,97 @@
+package csyaml_test
+
+import (
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/csyaml"
+)
+
+const queryDoc = `api:
+  server:
+    listen_uri: 127.0.0.1:8080
+    enable: true
+sources:
+  - name: nginx
+    labels:
+      "type.name": nginx
+  - ssh
+empty:
+`
+
+func TestGet(t *testing.T) {
+	var doc any
+
+	err := yaml.UnmarshalWithOptions([]byte(queryDoc), &doc, yaml.UseOrderedMap())
+	require.NoError(t, err)
+
+	tests := []struct {
+		path    string
+		want    any
+		wantErr string
+	}{
+		{path: "api.server.listen_uri", want: "127.0.0.1:8080"},
+		{path: "api.server.enable", want: true},
+		{path: `sources[0].labels."type.name"`, want: "nginx"},
+		{path: "sources[1]", want: "ssh"},
+		{path: "empty", want: nil},
+		{path: "api.client", wantErr: "api.client: path not found"},
+		{path: "sources[2]", wantErr: "sources[2]: path not found"},
+		{path: "api[0]", wantErr: "api[0]: path not found"},
+		{path: "api..server", wantErr: `invalid path "api..server": empty key`},
+		{path: "sources[x]", wantErr: `invalid path "sources[x]": bad index "x"`},
+		{path: `api."server`, wantErr: `invalid path "api.\"server": unterminated quote`},
+		{path: "sources[0]name", wantErr: `invalid path "sources[0]name": expected . or [ after sources[0]`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := csyaml.Get(doc, tc.path)
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				return
+			}
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	got, err := csyaml.Get(map[string]any{"a": []any{map[string]any{"b": 1}}}, "a[0].b")
+	require.NoError(t, err)
+	assert.Equal(t, 1, got)
+}
+
+func TestLookup(t *testing.T) {
+	res, err := csyaml.Lookup([]byte(queryDoc), "api.server.listen_uri")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8080", res.Value)
+	assert.Equal(t, csyaml.ScalarKind, res.Kind)
+	assert.Equal(t, 3, res.Line)
+	assert.Equal(t, 5, res.Column)
+	assert.Equal(t, "127.0.0.1:8080", res.Node.String())
+
+	res, err = csyaml.Lookup([]byte(queryDoc), "sources[0]")
+	require.NoError(t, err)
+	assert.Equal(t, csyaml.MappingKind, res.Kind)
+	assert.Equal(t, "mapping", res.Kind.String())
+	assert.Equal(t, 6, res.Line)
+	assert.Equal(t, 5, res.Column)
+
+	res, err = csyaml.Lookup([]byte(queryDoc), "sources")
+	require.NoError(t, err)
+	assert.Equal(t, csyaml.SequenceKind, res.Kind)
+	assert.Equal(t, 5, res.Line)
+
+	res, err = csyaml.Lookup([]byte(queryDoc), "empty")
+	require.NoError(t, err)
+	assert.Equal(t, csyaml.NullKind, res.Kind)
+	assert.Equal(t, 10, res.Line)
+
+	_, err = csyaml.Lookup([]byte(queryDoc), "api.client")
+	require.ErrorIs(t, err, csyaml.ErrPathNotFound)
+}
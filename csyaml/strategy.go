@@ -0,0 +1,149 @@
+package csyaml
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/token"
+)
+
+type strategyKind int
+
+const (
+	replaceItems strategyKind = iota
+	appendItems
+	prependItems
+	mergeItemsByKey
+)
+
+// MergeStrategy tells how a sequence from a patch is combined with the sequence
+// at the same path in the previous documents.
+type MergeStrategy struct {
+	kind strategyKind
+	key  string
+}
+
+var (
+	// Replace discards the previous sequence. This is the default.
+	Replace = MergeStrategy{kind: replaceItems}
+	// Append adds the items after the previous ones.
+	Append = MergeStrategy{kind: appendItems}
+	// Prepend adds the items before the previous ones.
+	Prepend = MergeStrategy{kind: prependItems}
+)
+
+// MergeByKey merges sequences of mappings: items with the same value for the
+// given field are deep-merged, the others are appended.
+func MergeByKey(field string) MergeStrategy {
+	return MergeStrategy{kind: mergeItemsByKey, key: field}
+}
+
+// String returns the tag that selects the strategy in a patch.
+func (s MergeStrategy) String() string {
+	switch s.kind {
+	case appendItems:
+		return "!append"
+	case prependItems:
+		return "!prepend"
+	case mergeItemsByKey:
+		return "!merge:" + s.key
+	default:
+		return "!replace"
+	}
+}
+
+// MergeOptions changes the behavior of MergeWithOptions().
+type MergeOptions struct {
+	// Strategies maps key paths to the strategy for the sequences found there.
+	// Paths are made of mapping keys separated by dots, like "filters.whitelist";
+	// sequence items are not part of the path, so the fields of items merged
+	// by key are addressed like "sources.labels".
+	//
+	// A patch can also set the strategy of a sequence with a tag: !replace,
+	// !append, !prepend, or !merge:<field>. Tags take precedence over options.
+	Strategies map[string]MergeStrategy
+}
+
+// parseStrategyTag returns the strategy selected by a tag, if it's one of ours.
+func parseStrategyTag(tag string) (MergeStrategy, bool, error) {
+	switch {
+	case tag == "!replace":
+		return Replace, true, nil
+	case tag == "!append":
+		return Append, true, nil
+	case tag == "!prepend":
+		return Prepend, true, nil
+	case strings.HasPrefix(tag, "!merge:"):
+		field := strings.TrimPrefix(tag, "!merge:")
+		if field == "" {
+			return MergeStrategy{}, false, fmt.Errorf("missing field name in tag %s", tag)
+		}
+
+		return MergeByKey(field), true, nil
+	case tag == "!merge":
+		return MergeStrategy{}, false, fmt.Errorf("missing field name in tag %s, use !merge:<field>", tag)
+	}
+
+	return MergeStrategy{}, false, nil
+}
+
+// nodeKey returns the mapping key of a node, as it's printed by fmt.Sprint() once decoded.
+func nodeKey(node ast.MapKeyNode) string {
+	if tok := node.GetToken(); tok != nil {
+		return tok.Value
+	}
+
+	return node.String()
+}
+
+// walkNodes calls fn for each value of a document, with its path and the token
+// that locates it: the key for the values of a mapping, the value itself otherwise.
+func walkNodes(node ast.Node, path docPath, pos *token.Token, fn func(path docPath, node ast.Node, pos *token.Token)) {
+	if node == nil {
+		return
+	}
+
+	if pos == nil {
+		pos = node.GetToken()
+
+		// the token of a mapping is the first ':'
+		if mapping, ok := node.(*ast.MappingNode); ok && len(mapping.Values) > 0 {
+			pos = mapping.Values[0].Key.GetToken()
+		}
+	}
+
+	if doc, ok := node.(*ast.DocumentNode); ok {
+		walkNodes(doc.Body, path, nil, fn)
+		return
+	}
+
+	fn(path, node, pos)
+
+	switch n := node.(type) {
+	case *ast.MappingValueNode:
+		// a mapping with a single key
+		walkNodes(n.Value, path.with(keySegment(nodeKey(n.Key))), n.Key.GetToken(), fn)
+	case *ast.TagNode:
+		walkNodes(n.Value, path, pos, fn)
+	case *ast.AnchorNode:
+		walkNodes(n.Value, path, pos, fn)
+	case *ast.MappingNode:
+		for _, value := range n.Values {
+			walkNodes(value.Value, path.with(keySegment(nodeKey(value.Key))), value.Key.GetToken(), fn)
+		}
+	case *ast.SequenceNode:
+		for i, value := range n.Values {
+			walkNodes(value, path.with(indexSegment(i)), nil, fn)
+		}
+	}
+}
+
+// collectTags records the local tags of a document (like !append) by path.
+func collectTags(doc ast.Node, tags map[string]string) {
+	walkNodes(doc, nil, nil, func(path docPath, node ast.Node, _ *token.Token) {
+		if tag, ok := node.(*ast.TagNode); ok {
+			tags[path.String()] = tag.Start.Value
+		}
+	})
+}
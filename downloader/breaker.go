@@ -0,0 +1,252 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Minute
+)
+
+// CircuitOpenError is returned by Download() without sending any request, when
+// the circuit breaker for the URL is open after too many consecutive failures.
+type CircuitOpenError struct {
+	URL      string
+	Failures int
+	// RetryAt is the end of the cooldown, when a new attempt is allowed.
+	RetryAt time.Time
+}
+
+func (e CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s after %d failures, retry at %s", e.URL, e.Failures, e.RetryAt.Format(time.RFC3339))
+}
+
+// breakerState is persisted for each URL.
+type breakerState struct {
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at,omitzero"`
+	// a single request is allowed after the cooldown, not persisted
+	probing bool
+}
+
+// CircuitBreaker stops the downloads of a URL after a number of consecutive failures,
+// until a cooldown has passed. Then a single attempt is allowed (half-open state):
+// if it succeeds, the circuit is closed, otherwise it's open for another cooldown.
+//
+// The state is saved to a file, so that it survives a restart. A breaker can be
+// shared by several Downloaders, and is safe for concurrent use.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	logger    *logrus.Entry
+	statePath string
+	states    map[string]*breakerState
+	threshold int
+	cooldown  time.Duration
+}
+
+// NewCircuitBreaker creates a circuit breaker that opens after 5 consecutive failures,
+// for 30 minutes. The state is kept in statePath, if not empty.
+func NewCircuitBreaker(statePath string) *CircuitBreaker {
+	return &CircuitBreaker{
+		logger:    nullLogger(),
+		statePath: statePath,
+		threshold: defaultBreakerThreshold,
+		cooldown:  defaultBreakerCooldown,
+	}
+}
+
+// WithLogger sets the logger for the circuit breaker.
+func (b *CircuitBreaker) WithLogger(logger *logrus.Entry) *CircuitBreaker {
+	b.logger = logger
+	return b
+}
+
+// WithThreshold sets the number of consecutive failures that open the circuit.
+func (b *CircuitBreaker) WithThreshold(failures int) *CircuitBreaker {
+	b.threshold = failures
+	return b
+}
+
+// WithCooldown sets how long the circuit stays open before a new attempt.
+func (b *CircuitBreaker) WithCooldown(cooldown time.Duration) *CircuitBreaker {
+	b.cooldown = cooldown
+	return b
+}
+
+// WithCircuitBreaker protects the downloads with a (possibly shared) circuit breaker.
+// When the circuit is open, Download() returns a CircuitOpenError, or uses the local
+// file with WithStaleOnError().
+func (d *Downloader) WithCircuitBreaker(breaker *CircuitBreaker) *Downloader {
+	d.breaker = breaker
+	return d
+}
+
+// load reads the state file the first time it's needed. Must be called with the lock held.
+func (b *CircuitBreaker) load() {
+	if b.states != nil {
+		return
+	}
+
+	b.states = make(map[string]*breakerState)
+
+	if b.statePath == "" {
+		return
+	}
+
+	content, err := os.ReadFile(b.statePath)
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return
+	case err != nil:
+		b.logger.Warnf("Failed to read circuit breaker state: %s", err)
+		return
+	}
+
+	if err = json.Unmarshal(content, &b.states); err != nil {
+		b.logger.Warnf("Ignoring corrupted circuit breaker state %s: %s", b.statePath, err)
+		b.states = make(map[string]*breakerState)
+	}
+}
+
+// save writes the state file. Must be called with the lock held.
+func (b *CircuitBreaker) save() {
+	if b.statePath == "" {
+		return
+	}
+
+	content, err := json.MarshalIndent(b.states, "", "  ")
+	if err == nil {
+		err = writeAtomic(b.statePath, bytes.NewReader(content), 0o644)
+	}
+
+	if err != nil {
+		b.logger.Warnf("Failed to save circuit breaker state: %s", err)
+	}
+}
+
+// allow returns a CircuitOpenError if a request to the URL must not be sent.
+func (b *CircuitBreaker) allow(url string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+
+	state, ok := b.states[url]
+	if !ok || state.Failures < b.threshold {
+		return nil
+	}
+
+	retryAt := state.OpenedAt.Add(b.cooldown)
+
+	if state.probing || time.Now().Before(retryAt) {
+		return CircuitOpenError{URL: url, Failures: state.Failures, RetryAt: retryAt}
+	}
+
+	b.logger.Infof("Circuit half-open for %s, trying again", url)
+
+	state.probing = true
+
+	return nil
+}
+
+// isEndpointFailure tells whether a download error comes from the server or the
+// network. The other errors (local files, options, content...) must not open
+// the circuit for all the Downloaders that share the breaker.
+func isEndpointFailure(err error) bool {
+	var (
+		codeErr  BadHTTPCodeError
+		notFound NotFoundError
+		urlErr   *url.Error
+		opErr    *net.OpError
+	)
+
+	switch {
+	case errors.As(err, &codeErr):
+		return codeErr.Code >= http.StatusInternalServerError || codeErr.Code == http.StatusTooManyRequests
+	case errors.As(err, &notFound), errors.As(err, &urlErr), errors.As(err, &opErr):
+		return true
+	}
+
+	// the connection was closed while reading the body
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// record updates the state of a URL after a request.
+func (b *CircuitBreaker) record(url string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+
+	state, ok := b.states[url]
+
+	// a cancellation or a local error says nothing about the server
+	if errors.Is(err, context.Canceled) || (err != nil && !isEndpointFailure(err)) {
+		if ok {
+			state.probing = false
+		}
+
+		return
+	}
+
+	if err == nil {
+		if ok {
+			if state.Failures >= b.threshold {
+				b.logger.Infof("Circuit closed for %s", url)
+			}
+
+			delete(b.states, url)
+			b.save()
+		}
+
+		return
+	}
+
+	if !ok {
+		state = &breakerState{}
+		b.states[url] = state
+	}
+
+	state.Failures++
+	state.probing = false
+
+	if state.Failures >= b.threshold {
+		state.OpenedAt = time.Now()
+		b.logger.Warnf("Circuit open for %s after %d failures, next attempt in %s: %s", url, state.Failures, b.cooldown, err)
+	}
+
+	b.save()
+}
+
+// guardedDownload calls download() through the circuit breaker, if there is one.
+func (d *Downloader) guardedDownload(ctx context.Context, url string, result *Result) (bool, error) {
+	if d.breaker == nil {
+		return d.download(ctx, url, result)
+	}
+
+	if err := d.breaker.allow(url); err != nil {
+		d.logger.Debug(err)
+		return false, err
+	}
+
+	downloaded, err := d.download(ctx, url, result)
+	d.breaker.record(url, err)
+
+	return downloaded, err
+}
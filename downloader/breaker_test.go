@@ -0,0 +1,168 @@
+package downloader_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		requests atomic.Int32
+		failing  atomic.Bool
+	)
+
+	failing.Store(true)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "example.txt")
+	statePath := filepath.Join(dir, "breaker.json")
+
+	newDownloader := func() *downloader.Downloader {
+		breaker := downloader.NewCircuitBreaker(statePath).
+			WithThreshold(2).
+			WithCooldown(200 * time.Millisecond)
+
+		return downloader.New().
+			ToFile(dest).
+			WithCircuitBreaker(breaker)
+	}
+
+	d := newDownloader()
+
+	var codeErr downloader.BadHTTPCodeError
+
+	for range 2 {
+		_, err := d.Download(ctx, ts.URL)
+		require.ErrorAs(t, err, &codeErr)
+	}
+
+	var openErr downloader.CircuitOpenError
+
+	_, err := d.Download(ctx, ts.URL)
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, ts.URL, openErr.URL)
+	assert.Equal(t, 2, openErr.Failures)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// the state survives a restart
+
+	_, err = newDownloader().Download(ctx, ts.URL)
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// after the cooldown, a failed attempt opens the circuit again
+
+	time.Sleep(250 * time.Millisecond)
+
+	_, err = d.Download(ctx, ts.URL)
+	require.ErrorAs(t, err, &codeErr)
+
+	_, err = d.Download(ctx, ts.URL)
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, 3, openErr.Failures)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// a successful attempt closes it
+
+	time.Sleep(250 * time.Millisecond)
+	failing.Store(false)
+
+	downloaded, err := d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+
+	state, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+	assert.JSONEq(t, "{}", string(state))
+}
+
+func TestCircuitBreakerStale(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+	require.NoError(t, os.WriteFile(dest, []byte("old content"), 0o600))
+
+	d := downloader.New().
+		ToFile(dest).
+		WithStaleOnError(time.Hour).
+		WithCircuitBreaker(downloader.NewCircuitBreaker("").WithThreshold(1))
+
+	result, err := d.DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeStale, result.Outcome)
+
+	result, err = d.DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeStale, result.Outcome)
+
+	var openErr downloader.CircuitOpenError
+
+	require.ErrorAs(t, result.Warning, &openErr)
+}
+
+func TestCircuitBreakerLocalError(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	breaker := downloader.NewCircuitBreaker("").WithThreshold(1)
+
+	// the destination directory does not exist
+	dest := filepath.Join(t.TempDir(), "missing", "example.txt")
+
+	for range 2 {
+		_, err := downloader.New().
+			ToFile(dest).
+			WithCircuitBreaker(breaker).
+			Download(ctx, ts.URL)
+		require.ErrorContains(t, err, "failed to create temporary download file")
+	}
+
+	assert.Equal(t, int32(2), requests.Load())
+
+	// the other downloaders are not blocked
+
+	downloaded, err := downloader.New().
+		ToFile(filepath.Join(t.TempDir(), "example.txt")).
+		WithCircuitBreaker(breaker).
+		Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+}
@@ -0,0 +1,478 @@
+package downloader
+
+import (
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Cache is a content-addressable store of downloaded files, that can be shared by
+// several Downloaders and processes. Files are stored by sha256, and indexed by
+// URL and strong ETag so that they can be found before the content is transferred.
+//
+// Writes are atomic (temporary file and rename), so processes don't need to
+// coordinate. A file that is evicted while in use is simply downloaded again.
+type Cache struct {
+	logger    *logrus.Entry
+	dir       string
+	maxSize   int64
+	maxAge    time.Duration
+	hardLinks bool
+}
+
+// NewCache creates a cache in the given directory, which is created if needed.
+func NewCache(dir string) *Cache {
+	return &Cache{
+		logger: nullLogger(),
+		dir:    dir,
+	}
+}
+
+// WithLogger sets the logger for the cache.
+func (c *Cache) WithLogger(logger *logrus.Entry) *Cache {
+	c.logger = logger
+	return c
+}
+
+// WithMaxSize sets the total size after which the least recently used files are evicted.
+func (c *Cache) WithMaxSize(size int64) *Cache {
+	c.maxSize = size
+	return c
+}
+
+// WithMaxAge sets the duration after which unused files are evicted.
+func (c *Cache) WithMaxAge(age time.Duration) *Cache {
+	c.maxAge = age
+	return c
+}
+
+// WithHardLinks installs the cached files as hard links instead of copies, when
+// no particular mode is required and the cache is on the same filesystem.
+// This saves space and time, but the destinations share their inode with the
+// cache and with each other: they are read-only (0444), have the modification
+// time of the cache entry, and must never be written in place, or the cache
+// and all the other destinations of the same content would be modified too.
+func (c *Cache) WithHardLinks(hardLinks bool) *Cache {
+	c.hardLinks = hardLinks
+	return c
+}
+
+// WithCache makes the downloader look for the content in a shared cache before
+// transferring it, and store it there after a successful download.
+// With VerifyHash("sha256", ...) the cache is checked without any request.
+func (d *Downloader) WithCache(cache *Cache) *Downloader {
+	d.cache = cache
+	return d
+}
+
+func (c *Cache) blobPath(sum string) string {
+	return filepath.Join(c.dir, "sha256", sum)
+}
+
+// usePath is the file whose modification time is the last use of a cached file.
+// The cached file itself is never touched, it can be linked to destinations.
+func (c *Cache) usePath(sum string) string {
+	return filepath.Join(c.dir, "used", sum)
+}
+
+func (c *Cache) indexPath(url, validator string) string {
+	h := crypto.SHA256.New()
+	h.Write([]byte(url + "\x00" + validator))
+
+	return filepath.Join(c.dir, "index", hex.EncodeToString(h.Sum(nil)))
+}
+
+// writeAtomic creates or replaces a file with the content of r, through a temporary file.
+func writeAtomic(path string, r io.Reader, mode fs.FileMode) error {
+	dir, name := filepath.Split(path)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return replaceFile(tmp.Name(), path)
+}
+
+// lookup returns the path of a cached file if it exists and has the expected hash.
+func (c *Cache) lookup(sum string) (string, bool) {
+	path := c.blobPath(sum)
+
+	got, err := hashContent(path, crypto.SHA256.New())
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "", false
+	case err != nil:
+		c.logger.Warnf("Can't read cached file %s: %s", path, err)
+		return "", false
+	case got != sum:
+		c.logger.Warnf("Cached file %s is corrupted, removing it", path)
+		_ = os.Remove(path)
+
+		return "", false
+	}
+
+	c.touch(sum)
+
+	return path, true
+}
+
+// touch records the use of a cached file, for eviction.
+func (c *Cache) touch(sum string) {
+	path := c.usePath(sum)
+	now := time.Now()
+
+	if err := os.Chtimes(path, now, now); errors.Is(err, fs.ErrNotExist) {
+		err = writeAtomic(path, strings.NewReader(""), 0o644)
+		if err != nil {
+			c.logger.Debugf("Can't record use of %s: %s", sum, err)
+		}
+	}
+}
+
+// lastUse returns when a cached file was stored or last used.
+func (c *Cache) lastUse(sum string, stored time.Time) time.Time {
+	info, err := os.Stat(c.usePath(sum))
+	if err != nil || info.ModTime().Before(stored) {
+		return stored
+	}
+
+	return info.ModTime()
+}
+
+// lookupValidator returns the hash of the content of a URL with the given ETag.
+func (c *Cache) lookupValidator(url, validator string) string {
+	if validator == "" {
+		return ""
+	}
+
+	content, err := os.ReadFile(c.indexPath(url, validator))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+// store copies a file to the cache and indexes it by URL and validator, if any.
+func (c *Cache) store(path, sum, url, validator string) error {
+	if _, err := os.Stat(c.blobPath(sum)); errors.Is(err, fs.ErrNotExist) {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		if err := writeAtomic(c.blobPath(sum), file, 0o444); err != nil {
+			return fmt.Errorf("while storing %s in cache: %w", path, err)
+		}
+	}
+
+	if validator != "" {
+		if err := writeAtomic(c.indexPath(url, validator), strings.NewReader(sum), 0o644); err != nil {
+			return fmt.Errorf("while indexing %s in cache: %w", url, err)
+		}
+	}
+
+	return c.Evict()
+}
+
+// install puts a cached file at the destination: a copy, or a hard link if
+// enabled, possible and no particular mode is required.
+func (c *Cache) install(blob, destPath string, mode fs.FileMode) error {
+	destDir, destName := filepath.Split(destPath)
+
+	if c.hardLinks && mode == 0 {
+		// reserve a name for the link
+		tmp, err := os.CreateTemp(destDir, destName+".*.download")
+		if err != nil {
+			return err
+		}
+
+		tmpName := tmp.Name()
+
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+
+		if err = os.Link(blob, tmpName); err == nil {
+			if err = replaceFile(tmpName, destPath); err != nil {
+				_ = os.Remove(tmpName)
+			}
+
+			return err
+		}
+
+		c.logger.Debugf("Can't link %s, copying: %s", blob, err)
+	}
+
+	if mode == 0 {
+		mode = 0o644
+	}
+
+	src, err := os.Open(blob)
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	return writeAtomic(destPath, src, mode)
+}
+
+// Evict removes the files that have not been used for longer than the maximum age,
+// then the least recently used ones until the cache fits the maximum size.
+// It's called after each new file is stored.
+func (c *Cache) Evict() error {
+	if c.maxAge == 0 && c.maxSize == 0 {
+		return nil
+	}
+
+	type blob struct {
+		sum     string
+		path    string
+		size    int64
+		lastUse time.Time
+	}
+
+	var blobs []blob
+
+	for _, sub := range []string{"sha256", "index"} {
+		entries, err := os.ReadDir(filepath.Join(c.dir, sub))
+
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			return fmt.Errorf("while reading cache: %w", err)
+		}
+
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				// removed by another process
+				continue
+			}
+
+			path := filepath.Join(c.dir, sub, entry.Name())
+
+			lastUse := info.ModTime()
+			if sub == "sha256" {
+				lastUse = c.lastUse(entry.Name(), lastUse)
+			}
+
+			if c.maxAge > 0 && time.Since(lastUse) > c.maxAge {
+				c.logger.Debugf("Evicting %s (age)", path)
+				c.removeEvicted(sub, entry.Name())
+
+				continue
+			}
+
+			if sub == "sha256" {
+				blobs = append(blobs, blob{sum: entry.Name(), path: path, size: info.Size(), lastUse: lastUse})
+			}
+		}
+	}
+
+	if c.maxSize == 0 {
+		return nil
+	}
+
+	total := int64(0)
+	for _, b := range blobs {
+		total += b.size
+	}
+
+	// oldest first
+	slices.SortFunc(blobs, func(a, b blob) int {
+		return a.lastUse.Compare(b.lastUse)
+	})
+
+	for _, b := range blobs {
+		if total <= c.maxSize {
+			break
+		}
+
+		c.logger.Debugf("Evicting %s (size)", b.path)
+		c.removeEvicted("sha256", b.sum)
+
+		total -= b.size
+	}
+
+	return nil
+}
+
+// removeEvicted deletes a cache file and its last use, ignoring the ones already
+// removed by another process. Index entries pointing to evicted files are
+// harmless and expire with their age.
+func (c *Cache) removeEvicted(sub, name string) {
+	_ = os.Remove(filepath.Join(c.dir, sub, name))
+
+	if sub == "sha256" {
+		_ = os.Remove(c.usePath(name))
+	}
+}
+
+// validator returns the value that identifies the exact content of a response:
+// a strong ETag. Neither a weak ETag nor Last-Modified, which has a resolution
+// of one second, guarantee the same bytes.
+func validator(resp *http.Response) string {
+	etag := resp.Header.Get("ETag")
+	if strings.HasPrefix(etag, "W/") {
+		return ""
+	}
+
+	return etag
+}
+
+// hasContent returns true if the destination file exists and has the given sha256.
+func (d *Downloader) hasContent(sum string) bool {
+	current, err := hashContent(d.destPath, crypto.SHA256.New())
+	return err == nil && current == sum
+}
+
+// verifyCachedContent checks a file found with the validator of a response,
+// like the downloaded content would be: against the hash set with VerifyHash()
+// and the digest headers. These apply to the content as transferred, so they
+// can't be checked if it was compressed.
+func (d *Downloader) verifyCachedContent(path string, resp *http.Response, hashFunction, hashValue string) error {
+	digests, err := d.newDigestVerifier(resp)
+	if err != nil {
+		return err
+	}
+
+	if enc := resp.Header.Get("Content-Encoding"); digests != nil && enc != "" && enc != "identity" {
+		return fmt.Errorf("the digest headers apply to the %s content", enc)
+	}
+
+	hasher, err := selectHashFunction(hashFunction)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if err = hashFile(file, digests, hasher); err != nil {
+		return err
+	}
+
+	if digests != nil {
+		if err = digests.verify(); err != nil {
+			return err
+		}
+	}
+
+	if hasher != nil {
+		if got := hex.EncodeToString(hasher.Sum(nil)); got != hashValue {
+			return HashMismatchError{Expected: hashValue, Got: got}
+		}
+	}
+
+	return nil
+}
+
+// installFromCache puts the cached file with the given hash at the destination,
+// and stores its metadata from resp, which is nil if no request has been made.
+// It returns false without error if the file is not in the cache.
+func (d *Downloader) installFromCache(sum string, mode fs.FileMode, resp *http.Response, result *Result) (bool, error) {
+	blob, ok := d.cache.lookup(sum)
+	if !ok {
+		return false, nil
+	}
+
+	if d.makeDirs {
+		if err := os.MkdirAll(filepath.Dir(d.destPath), 0o755); err != nil {
+			return false, fmt.Errorf("failed to create directories for %s: %w", d.destPath, err)
+		}
+	}
+
+	target := d.destPath
+
+	if d.staging {
+		// the file is put in place when the transaction is committed
+		tmp, err := os.CreateTemp(filepath.Dir(d.destPath), filepath.Base(d.destPath)+".*.download")
+		if err != nil {
+			return false, fmt.Errorf("failed to create temporary download file for %s: %w", d.destPath, err)
+		}
+
+		_ = tmp.Close()
+		target = tmp.Name()
+	}
+
+	if err := d.cache.install(blob, target, mode); err != nil {
+		d.logger.Warnf("Failed to install %s from cache: %s", d.destPath, err)
+
+		if d.staging {
+			_ = os.Remove(target)
+		}
+
+		return false, nil
+	}
+
+	d.logger.Debugf("Installed %s from cache", d.destPath)
+
+	result.FromCache = true
+
+	if d.staging {
+		result.staged = &stagedFile{path: target, finish: func() { d.storeMetadata(resp) }}
+		return true, nil
+	}
+
+	d.storeMetadata(resp)
+
+	return true, nil
+}
+
+// storeInCache adds a downloaded file to the cache, if there is one.
+// Errors are logged, the download still succeeds.
+func (d *Downloader) storeInCache(path string, resp *http.Response) {
+	if d.cache == nil {
+		return
+	}
+
+	sum, err := hashContent(path, crypto.SHA256.New())
+	if err == nil {
+		err = d.cache.store(path, sum, resp.Request.URL.String(), validator(resp))
+	}
+
+	if err != nil {
+		d.logger.Warnf("Failed to store %s in cache: %s", d.destPath, err)
+	}
+}
@@ -0,0 +1,416 @@
+package downloader_test
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // testing VerifyHash("md5", ...)
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestCacheByHash(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	cache := downloader.NewCache(filepath.Join(dir, "cache"))
+
+	result, err := downloader.New().
+		ToFile(filepath.Join(dir, "first.txt")).
+		VerifyHash("sha256", sha256hex("content")).
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeDownloaded, result.Outcome)
+	assert.False(t, result.FromCache)
+	assert.Equal(t, int32(1), requests.Load())
+
+	second := filepath.Join(dir, "sub", "second.txt")
+
+	result, err = downloader.New().
+		ToFile(second).
+		WithMakeDirs(true).
+		VerifyHash("sha256", sha256hex("content")).
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeDownloaded, result.Outcome)
+	assert.True(t, result.FromCache)
+	assert.Equal(t, int32(1), requests.Load())
+
+	content, err := os.ReadFile(second)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	// a corrupted cache entry is not used
+
+	require.NoError(t, os.Chmod(filepath.Join(dir, "cache", "sha256", sha256hex("content")), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cache", "sha256", sha256hex("content")), []byte("garbage"), 0o644))
+
+	third := filepath.Join(dir, "third.txt")
+
+	result, err = downloader.New().
+		ToFile(third).
+		WithMode(0o600).
+		VerifyHash("sha256", sha256hex("content")).
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, result.FromCache)
+	assert.Equal(t, int32(2), requests.Load())
+
+	info, err := os.Stat(third)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestCacheByValidator(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	cache := downloader.NewCache(filepath.Join(dir, "cache"))
+
+	_, err := downloader.New().
+		ToFile(filepath.Join(dir, "first.txt")).
+		WithCache(cache).
+		Download(ctx, ts.URL)
+	require.NoError(t, err)
+
+	second := filepath.Join(dir, "second.txt")
+
+	result, err := downloader.New().
+		ToFile(second).
+		WithMode(0o640).
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, result.FromCache)
+	assert.Equal(t, downloader.OutcomeDownloaded, result.Outcome)
+
+	content, err := os.ReadFile(second)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	info, err := os.Stat(second)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+}
+
+func TestCacheWeakValidators(t *testing.T) {
+	ctx := context.Background()
+
+	modTime := time.Now().Add(-time.Hour)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/weak" {
+			w.Header().Set("ETag", `W/"v1"`)
+		}
+
+		http.ServeContent(w, r, "", modTime, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	cache := downloader.NewCache(filepath.Join(dir, "cache"))
+
+	// the content is cached, but can only be found by hash
+
+	for _, path := range []string{"/weak", "/last-modified"} {
+		for _, name := range []string{"first", "second"} {
+			result, err := downloader.New().
+				ToFile(filepath.Join(dir, name+path)).
+				WithMakeDirs(true).
+				WithCache(cache).
+				DownloadWithResult(ctx, ts.URL+path)
+			require.NoError(t, err)
+			assert.False(t, result.FromCache)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "cache", "sha256"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.NoDirExists(t, filepath.Join(dir, "cache", "index"))
+}
+
+func TestCacheByValidatorVerified(t *testing.T) {
+	ctx := context.Background()
+
+	var badDigest atomic.Bool
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if badDigest.Load() {
+			w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(make([]byte, 16)))
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	cache := downloader.NewCache(filepath.Join(dir, "cache"))
+
+	_, err := downloader.New().
+		ToFile(filepath.Join(dir, "first.txt")).
+		WithCache(cache).
+		Download(ctx, ts.URL)
+	require.NoError(t, err)
+
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s)) //nolint:gosec
+		return hex.EncodeToString(sum[:])
+	}
+
+	result, err := downloader.New().
+		ToFile(filepath.Join(dir, "md5.txt")).
+		VerifyHash("md5", md5hex("content")).
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, result.FromCache)
+
+	// the cached content is checked like a download, and then downloaded again
+
+	var mismatch downloader.HashMismatchError
+
+	_, err = downloader.New().
+		ToFile(filepath.Join(dir, "wrong.txt")).
+		VerifyHash("md5", md5hex("other")).
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.ErrorAs(t, err, &mismatch)
+	assert.NoFileExists(t, filepath.Join(dir, "wrong.txt"))
+
+	badDigest.Store(true)
+
+	_, err = downloader.New().
+		ToFile(filepath.Join(dir, "digest.txt")).
+		VerifyDigestHeaders(true).
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.ErrorAs(t, err, &mismatch)
+	assert.NoFileExists(t, filepath.Join(dir, "digest.txt"))
+}
+
+func TestCacheMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	cache := downloader.NewCache(filepath.Join(dir, "cache"))
+
+	_, err := downloader.New().
+		ToFile(filepath.Join(dir, "first.txt")).
+		WithCache(cache).
+		Download(ctx, ts.URL)
+	require.NoError(t, err)
+
+	requireContent := func(path, expected string) {
+		t.Helper()
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	// installed by validator: the sidecars come from the response
+
+	second := filepath.Join(dir, "second.txt")
+
+	result, err := downloader.New().
+		ToFile(second).
+		WithETagFile(second+".etag").
+		WithIntegrityCheck(second+".sha256").
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, result.FromCache)
+	requireContent(second+".etag", `"v1"`)
+	requireContent(second+".sha256", sha256hex("content"))
+
+	// installed by hash in a transaction: the sidecars are written on commit
+
+	third := filepath.Join(dir, "third.txt")
+
+	results, err := downloader.NewTransaction().
+		Add(downloader.New().
+			ToFile(third).
+			WithIntegrityCheck(third+".sha256").
+			VerifyHash("sha256", sha256hex("content")).
+			WithCache(cache), ts.URL).
+		Commit(ctx)
+	require.NoError(t, err)
+	assert.True(t, results[0].FromCache)
+	requireContent(third, "content")
+	requireContent(third+".sha256", sha256hex("content"))
+
+	// the destination already has the content
+
+	before := requests.Load()
+
+	result, err = downloader.New().
+		ToFile(third).
+		WithIntegrityCheck(third+".sha256").
+		VerifyHash("sha256", sha256hex("content")).
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeUpToDate, result.Outcome)
+	assert.False(t, result.FromCache)
+	assert.Equal(t, before, requests.Load())
+
+	// without an ETag file, the content is transferred but found in the cache
+	result, err = downloader.New().
+		ToFile(second).
+		WithIntegrityCheck(second+".sha256").
+		WithCache(cache).
+		DownloadWithResult(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeUpToDate, result.Outcome)
+	assert.Equal(t, before+1, requests.Load())
+}
+
+func TestCacheHardLinks(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	blob := filepath.Join(cacheDir, "sha256", sha256hex("content"))
+
+	install := func(cache *downloader.Cache, name string) os.FileInfo {
+		t.Helper()
+
+		dest := filepath.Join(dir, name)
+
+		result, err := downloader.New().
+			ToFile(dest).
+			VerifyHash("sha256", sha256hex("content")).
+			WithCache(cache).
+			DownloadWithResult(ctx, ts.URL)
+		require.NoError(t, err)
+
+		info, err := os.Stat(dest)
+		require.NoError(t, err)
+
+		if name != "first.txt" {
+			assert.True(t, result.FromCache)
+		}
+
+		return info
+	}
+
+	cache := downloader.NewCache(cacheDir)
+
+	install(cache, "first.txt")
+
+	blobInfo, err := os.Stat(blob)
+	require.NoError(t, err)
+
+	// copied by default
+
+	info := install(cache, "copy.txt")
+	assert.False(t, os.SameFile(blobInfo, info))
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	// linked if enabled, and the use of the cache doesn't change the destinations
+
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(blob, old, old))
+
+	linked := install(cache.WithHardLinks(true), "linked.txt")
+	assert.True(t, os.SameFile(blobInfo, linked))
+
+	install(cache, "other.txt")
+
+	info, err = os.Stat(filepath.Join(dir, "linked.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, linked.ModTime(), info.ModTime())
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(strings.Repeat("x", 100)+r.URL.Path))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	cache := downloader.NewCache(cacheDir).WithMaxSize(250)
+
+	blobs := func() []string {
+		entries, err := os.ReadDir(filepath.Join(cacheDir, "sha256"))
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+
+		return names
+	}
+
+	for i, path := range []string{"/a", "/b", "/c"} {
+		_, err := downloader.New().
+			ToFile(filepath.Join(dir, "file"+path[1:])).
+			WithCache(cache).
+			Download(ctx, ts.URL+path)
+		require.NoError(t, err)
+
+		// make sure the files have distinct access times
+		old := time.Now().Add(time.Duration(i-10) * time.Minute)
+		require.NoError(t, os.Chtimes(filepath.Join(cacheDir, "sha256", sha256hex(strings.Repeat("x", 100)+path)), old, old))
+	}
+
+	// the oldest has been evicted
+	assert.ElementsMatch(t, []string{
+		sha256hex(strings.Repeat("x", 100) + "/b"),
+		sha256hex(strings.Repeat("x", 100) + "/c"),
+	}, blobs())
+
+	require.NoError(t, cache.WithMaxAge(time.Minute).Evict())
+	assert.Empty(t, blobs())
+}
@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CheckResult describes the remote file and whether it differs from the local one.
+type CheckResult struct {
+	// Changed is true if Download() would transfer the remote file, because
+	// the local one is missing, corrupted (see WithIntegrityCheck()) or outdated
+	// according to the validators. The content is not compared: with
+	// CompareContent() or a cache, Download() can still keep the local file.
+	Changed      bool
+	ETag         string
+	LastModified string
+	// ContentLength is -1 if the server did not send it.
+	ContentLength int64
+	// Reason explains the verdict, for the user.
+	Reason string
+}
+
+// doCheckRequest sends a request with the same validators and hooks as Download().
+func (d *Downloader) doCheckRequest(ctx context.Context, method, url string, etag *boundETag, modifiedSince string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request for %s: %w", method, url, err)
+	}
+
+	// we want the real size, not the compressed one
+	req.Header.Set("Accept-Encoding", "identity")
+
+	if modifiedSince != "" {
+		req.Header.Set("If-Modified-Since", modifiedSince)
+	}
+
+	if d.beforeRequest != nil {
+		d.beforeRequest(req)
+	}
+
+	resp, err := d.do(req, etag)
+	if err != nil {
+		return nil, fmt.Errorf("failed %s request for %s: %w", method, url, err)
+	}
+
+	if d.afterRequest != nil {
+		d.afterRequest(resp)
+	}
+
+	return resp, nil
+}
+
+// checkRemote sends a HEAD request, or a GET without reading the body if HEAD is
+// not supported or WithConditionalGet() is set.
+func (d *Downloader) checkRemote(ctx context.Context, url string, etag *boundETag, modifiedSince string) (*http.Response, error) {
+	if d.conditionalGet {
+		return d.doCheckRequest(ctx, http.MethodGet, url, etag, modifiedSince)
+	}
+
+	resp, err := d.doCheckRequest(ctx, http.MethodHead, url, etag, modifiedSince)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		d.logger.Debugf("HEAD request rejected with %d, trying GET", resp.StatusCode)
+		resp.Body.Close()
+
+		return d.doCheckRequest(ctx, http.MethodGet, url, etag, modifiedSince)
+	}
+
+	return resp, nil
+}
+
+// Check tells whether the remote file differs from the local one, using the same
+// options as Download() (ETag, Last-Modified, If-Modified-Since, shelf life, request hooks).
+// The content is not downloaded, and neither the local file nor the ETag or
+// Last-Modified files are modified.
+func (d *Downloader) Check(ctx context.Context, url string) (*CheckResult, error) {
+	if err := d.ValidateOptions(); err != nil {
+		return nil, fmt.Errorf("downloader options: %w", err)
+	}
+
+	destModTime, _ := d.getDestInfo()
+
+	// a corrupted file is handled like a missing one, as in Download()
+	corrupted := !destModTime.IsZero() && !d.isLocalIntact("")
+	if corrupted {
+		destModTime = time.Time{}
+	}
+
+	etag, err := d.getETag(destModTime)
+	if err != nil {
+		d.logger.Warnf("Failed to get etag: %s", err)
+	}
+
+	resp, err := d.checkRemote(ctx, url, d.bindETag(url, etag), d.modifiedSince(destModTime, true))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	result := &CheckResult{
+		ETag:          resp.Header.Get("ETag"),
+		LastModified:  resp.Header.Get("Last-Modified"),
+		ContentLength: resp.ContentLength,
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, NotFoundError{url}
+	case http.StatusNotModified:
+		result.Reason = "not modified"
+		return result, nil
+	case http.StatusOK:
+		break
+	default:
+		return nil, BadHTTPCodeError{url, resp.StatusCode}
+	}
+
+	fresh := false
+
+	switch {
+	case corrupted:
+		result.Reason = "local file is corrupted"
+	case destModTime == time.Time{}:
+		result.Reason = "local file does not exist"
+	case etag != "":
+		result.Reason = "ETag has changed"
+	case d.etagFn != nil:
+		result.Reason = "no local ETag"
+	case d.lastModified:
+		fresh, result.Reason = d.compareLastModified(result.LastModified, destModTime)
+	case d.ifModifiedSince:
+		result.Reason = "modified since the local file"
+	default:
+		result.Reason = "no way to compare with the local file"
+	}
+
+	result.Changed = !fresh
+
+	d.logger.Debugf("%s: %s", d.destPath, result.Reason)
+
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package downloader_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+
+	etag := `"v1"`
+	methods := []string{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "example.txt")
+
+	d := downloader.New().
+		ToFile(dest).
+		WithETagFile(dest + ".etag").
+		BeforeRequest(func(r *http.Request) {
+			r.Header.Set("Authorization", "secret")
+		})
+
+	result, err := d.Check(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, &downloader.CheckResult{
+		Changed:       true,
+		ETag:          `"v1"`,
+		ContentLength: 7,
+		Reason:        "local file does not exist",
+	}, result)
+
+	// nothing has been written
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	_, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+
+	result, err = d.Check(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, result.Changed)
+	assert.Equal(t, "not modified", result.Reason)
+
+	etag = `"v2"`
+
+	result, err = d.Check(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, result.Changed)
+	assert.Equal(t, `"v2"`, result.ETag)
+	assert.Equal(t, "ETag has changed", result.Reason)
+
+	stored, err := os.ReadFile(dest + ".etag")
+	require.NoError(t, err)
+	assert.Equal(t, `"v1"`, string(stored))
+
+	_, err = d.Check(ctx, ts.URL+"/missing")
+
+	var notFound downloader.NotFoundError
+
+	require.ErrorAs(t, err, &notFound)
+
+	// all the checks were HEAD requests
+
+	methods = methods[:0]
+
+	_, err = d.Check(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HEAD"}, methods)
+}
+
+func TestCheckLastModified(t *testing.T) {
+	ctx := context.Background()
+
+	remoteModTime := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// ignore If-Modified-Since to test the comparison on our side
+		w.Header().Set("Last-Modified", remoteModTime.Format(http.TimeFormat))
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+	require.NoError(t, os.WriteFile(dest, []byte("old"), 0o600))
+
+	d := downloader.New().
+		ToFile(dest).
+		WithLastModified()
+
+	result, err := d.Check(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, result.Changed)
+	assert.Equal(t, remoteModTime.Format(http.TimeFormat), result.LastModified)
+	assert.Contains(t, result.Reason, "local file is newer than remote")
+
+	// the local file is newer, but doesn't have the expected content
+
+	result, err = downloader.New().
+		ToFile(dest).
+		WithLastModified().
+		VerifyHash("sha256", sha256hex("content")).
+		WithIntegrityCheck("").
+		Check(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, result.Changed)
+	assert.Equal(t, "local file is corrupted", result.Reason)
+
+	older := remoteModTime.Add(-time.Hour)
+	require.NoError(t, os.Chtimes(dest, older, older))
+
+	result, err = d.Check(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, result.Changed)
+	assert.Contains(t, result.Reason, "remote file is newer than local")
+
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+}
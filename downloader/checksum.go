@@ -0,0 +1,187 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxChecksumFileSize limits the size of a checksum file, a SHA256SUMS
+// with a few thousand entries fits easily
+const maxChecksumFileSize = 1 << 20
+
+// ErrNoChecksumEntry is returned when the checksum file has no entry for the downloaded file.
+var ErrNoChecksumEntry = errors.New("no checksum for file")
+
+var (
+	// SHA256 (file.tar.gz) = 0123...
+	bsdChecksumLine = regexp.MustCompile(`^SHA256 ?\((.*)\) ?= ?([0-9a-fA-F]{64})$`)
+	// 0123...  file.tar.gz, or with '*' for binary mode
+	gnuChecksumLine = regexp.MustCompile(`^\\?([0-9a-fA-F]{64}) [ *](.+)$`)
+	// 0123...
+	bareChecksumLine = regexp.MustCompile(`^([0-9a-fA-F]{64})$`)
+)
+
+// VerifyChecksumFile fetches a checksum file before each download and verifies the
+// downloaded file against its sha256 entry. The URL can be relative to the download URL,
+// like "SHA256SUMS" or "file.tar.gz.sha256".
+//
+// The file can contain a single hash, or the output of sha256sum (GNU) or
+// sha256 -r (BSD). The entry is found by the name of the downloaded file.
+// The hash replaces any value set with VerifyHash().
+func (d *Downloader) VerifyChecksumFile(checksumURL string) *Downloader {
+	d.checksumURL = checksumURL
+	return d
+}
+
+// checksumEntry is a line of a checksum file.
+type checksumEntry struct {
+	name string
+	sum  string
+}
+
+// unescapeChecksumName decodes the file names escaped by GNU coreutils,
+// for the lines that begin with a backslash.
+func unescapeChecksumName(name string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(name)
+}
+
+// parseChecksumFile reads the entries of a checksum file. A single hash
+// without file name is returned as an entry with an empty name.
+func parseChecksumFile(r io.Reader) ([]checksumEntry, error) {
+	var entries []checksumEntry
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := bsdChecksumLine.FindStringSubmatch(line); m != nil {
+			entries = append(entries, checksumEntry{name: m[1], sum: strings.ToLower(m[2])})
+			continue
+		}
+
+		if m := gnuChecksumLine.FindStringSubmatch(line); m != nil {
+			name := m[2]
+			if strings.HasPrefix(line, `\`) {
+				name = unescapeChecksumName(name)
+			}
+
+			entries = append(entries, checksumEntry{name: name, sum: strings.ToLower(m[1])})
+
+			continue
+		}
+
+		if m := bareChecksumLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			entries = append(entries, checksumEntry{sum: strings.ToLower(m[1])})
+			continue
+		}
+
+		return nil, fmt.Errorf("line %d: unrecognized format", lineNum)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// findChecksum returns the hash of the named file. The name in the entry
+// can have a directory ("./file", "dist/file"), an exact match is preferred.
+func findChecksum(entries []checksumEntry, name string) (string, error) {
+	if len(entries) == 1 && entries[0].name == "" {
+		return entries[0].sum, nil
+	}
+
+	byBase := map[string]bool{}
+
+	for _, e := range entries {
+		if e.name == name {
+			return e.sum, nil
+		}
+
+		if path.Base(e.name) == name {
+			byBase[e.sum] = true
+		}
+	}
+
+	switch len(byBase) {
+	case 0:
+		return "", fmt.Errorf("%w %s", ErrNoChecksumEntry, name)
+	case 1:
+		for sum := range byBase {
+			return sum, nil
+		}
+	}
+
+	return "", fmt.Errorf("ambiguous checksum entries for %s", name)
+}
+
+// fetchChecksum downloads the checksum file and returns the hash of the file at fileURL.
+func (d *Downloader) fetchChecksum(ctx context.Context, fileURL string) (string, error) {
+	base, err := url.Parse(fileURL)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(d.checksumURL)
+	if err != nil {
+		return "", err
+	}
+
+	checksumURL := base.ResolveReference(ref).String()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checksumURL, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+
+	d.logger.Debugf("Fetching checksum file %s", checksumURL)
+
+	if d.beforeRequest != nil {
+		d.beforeRequest(req)
+	}
+
+	resp, err := d.do(req, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if d.afterRequest != nil {
+		d.afterRequest(resp)
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	case http.StatusNotFound:
+		return "", NotFoundError{checksumURL}
+	default:
+		return "", BadHTTPCodeError{checksumURL, resp.StatusCode}
+	}
+
+	body := &wireReader{r: resp.Body, limit: maxChecksumFileSize}
+
+	entries, err := parseChecksumFile(body)
+	if err != nil {
+		return "", fmt.Errorf("while parsing %s: %w", checksumURL, err)
+	}
+
+	return findChecksum(entries, path.Base(base.Path))
+}
@@ -0,0 +1,109 @@
+package downloader_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestVerifyChecksumFile(t *testing.T) {
+	ctx := context.Background()
+
+	sum := sha256hex("content")
+	other := sha256hex("other")
+
+	files := map[string]string{
+		"/dist/file.tar.gz":        "content",
+		"/dist/file.tar.gz.sha256": sum + "\n",
+		"/dist/SHA256SUMS":         other + "  other.tar.gz\n" + sum + " *./file.tar.gz\n",
+		"/dist/BSD":                "SHA256 (other.tar.gz) = " + other + "\nSHA256 (file.tar.gz) = " + sum + "\n",
+		"/dist/WRONG":              other + "  file.tar.gz\n",
+		"/dist/MISSING":            other + "  other.tar.gz\n",
+		"/dist/GARBAGE":            "this is not a checksum\n",
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = io.WriteString(w, content)
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "file.tar.gz")
+
+	for _, checksumURL := range []string{"file.tar.gz.sha256", "SHA256SUMS", ts.URL + "/dist/BSD"} {
+		t.Run(checksumURL, func(t *testing.T) {
+			_ = os.Remove(dest)
+
+			downloaded, err := downloader.New().
+				ToFile(dest).
+				VerifyChecksumFile(checksumURL).
+				Download(ctx, ts.URL+"/dist/file.tar.gz")
+			require.NoError(t, err)
+			assert.True(t, downloaded)
+		})
+	}
+
+	_ = os.Remove(dest)
+
+	var mismatch downloader.HashMismatchError
+
+	_, err := downloader.New().
+		ToFile(dest).
+		VerifyChecksumFile("WRONG").
+		Download(ctx, ts.URL+"/dist/file.tar.gz")
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, other, mismatch.Expected)
+	assert.NoFileExists(t, dest)
+
+	_, err = downloader.New().
+		ToFile(dest).
+		VerifyChecksumFile("MISSING").
+		Download(ctx, ts.URL+"/dist/file.tar.gz")
+	require.ErrorIs(t, err, downloader.ErrNoChecksumEntry)
+	require.ErrorContains(t, err, "no checksum for file file.tar.gz")
+
+	_, err = downloader.New().
+		ToFile(dest).
+		VerifyChecksumFile("GARBAGE").
+		Download(ctx, ts.URL+"/dist/file.tar.gz")
+	require.ErrorContains(t, err, "GARBAGE: line 1: unrecognized format")
+
+	var notFound downloader.NotFoundError
+
+	_, err = downloader.New().
+		ToFile(dest).
+		VerifyChecksumFile("NOPE").
+		Download(ctx, ts.URL+"/dist/file.tar.gz")
+	require.ErrorAs(t, err, &notFound)
+	assert.Equal(t, ts.URL+"/dist/NOPE", notFound.URL)
+
+	// the checksum request goes through the hooks too
+
+	_ = os.Remove(dest)
+
+	var requested, responded []string
+
+	_, err = downloader.New().
+		ToFile(dest).
+		VerifyChecksumFile("SHA256SUMS").
+		BeforeRequest(func(req *http.Request) { requested = append(requested, req.URL.Path) }).
+		AfterRequest(func(resp *http.Response) { responded = append(responded, resp.Request.URL.Path) }).
+		Download(ctx, ts.URL+"/dist/file.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/dist/SHA256SUMS", "/dist/file.tar.gz"}, requested)
+	assert.Equal(t, requested, responded)
+}
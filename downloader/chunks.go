@@ -0,0 +1,273 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// don't bother splitting files in parts smaller than this
+	minChunkSize = 1 << 20
+	// attempts for each part, including the first one
+	chunkAttempts   = 3
+	chunkRetryDelay = 500 * time.Millisecond
+)
+
+// WithParallelChunks enables downloading large files as n parts, in parallel.
+// This is only done if the server supports range requests (Accept-Ranges: bytes)
+// and reports the size of the content, otherwise the file is downloaded as a single stream.
+// Each part is retried separately in case of network or server error.
+func (d *Downloader) WithParallelChunks(n int) *Downloader {
+	d.chunks = n
+	return d
+}
+
+// chunkCount returns the number of parts to download in parallel, or 1 if the
+// response must be read as a single stream.
+func (d *Downloader) chunkCount(resp *http.Response) int {
+	if d.chunks < 2 || resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 {
+		return 1
+	}
+
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		d.logger.Debug("Server does not accept ranges, downloading as a single stream")
+		return 1
+	}
+
+	// ranges of a compressed response are ranges of the compressed content,
+	// we don't want to go there
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return 1
+	}
+
+	n := int(min(int64(d.chunks), (resp.ContentLength+minChunkSize-1)/minChunkSize))
+
+	return max(n, 1)
+}
+
+// chunk is a range of bytes to download, "done" is how much has already been written.
+type chunk struct {
+	start int64
+	end   int64 // inclusive, like in the Range header
+	done  int64
+}
+
+func (c *chunk) size() int64 {
+	return c.end - c.start + 1
+}
+
+// permanentError is a failure that won't go away by retrying.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// downloadChunks writes the content to the file with parallel range requests.
+// The first part is read from the response we already have, unless the host
+// policy limits the concurrent requests: the response would hold a slot while
+// the other parts wait for one, so it's closed and the first part is requested
+// like the others.
+func (d *Downloader) downloadChunks(ctx context.Context, url string, resp *http.Response, file *os.File, n int) (int64, error) {
+	size := resp.ContentLength
+
+	var firstBody io.Reader = resp.Body
+
+	if d.hostPolicy != nil && d.hostPolicy.maxConcurrent > 0 {
+		resp.Body.Close()
+
+		firstBody = nil
+	}
+
+	if err := file.Truncate(size); err != nil {
+		return 0, fmt.Errorf("while allocating %s: %w", file.Name(), err)
+	}
+
+	// If-Range makes sure all the parts come from the same version of the file
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	chunkSize := (size + int64(n) - 1) / int64(n)
+	chunks := make([]*chunk, 0, n)
+
+	for start := int64(0); start < size; start += chunkSize {
+		chunks = append(chunks, &chunk{start: start, end: min(start+chunkSize, size) - 1})
+	}
+
+	d.logger.Debugf("Downloading %s in %d parts of %d bytes", url, len(chunks), chunkSize)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, len(chunks))
+
+	var wg sync.WaitGroup
+
+	for i, c := range chunks {
+		wg.Go(func() {
+			var body io.Reader
+
+			if i == 0 {
+				body = firstBody
+			}
+
+			if err := d.fetchChunk(ctx, url, validator, c, file, body); err != nil {
+				errs[i] = fmt.Errorf("part %d (bytes %d-%d): %w", i, c.start, c.end, err)
+
+				cancel()
+			}
+		})
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// fetchChunk downloads a part of the file, retrying on transient errors and
+// resuming from the last byte written. If body is not nil, it is used for the first attempt.
+func (d *Downloader) fetchChunk(ctx context.Context, url, validator string, c *chunk, file *os.File, body io.Reader) error {
+	var err error
+
+	for attempt := range chunkAttempts {
+		if attempt > 0 {
+			d.logger.Debugf("Retrying bytes %d-%d of %s: %s", c.start+c.done, c.end, url, err)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(chunkRetryDelay * time.Duration(attempt)):
+			}
+		}
+
+		if attempt == 0 && body != nil {
+			err = c.copyFrom(body, file)
+		} else {
+			err = d.requestChunk(ctx, url, validator, c, file)
+		}
+
+		var permanent permanentError
+
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &permanent):
+			return err
+		case ctx.Err() != nil:
+			return ctx.Err()
+		}
+	}
+
+	return err
+}
+
+// copyFrom writes the remaining bytes of the chunk from r.
+func (c *chunk) copyFrom(r io.Reader, file *os.File) error {
+	w := io.NewOffsetWriter(file, c.start+c.done)
+
+	remaining := c.size() - c.done
+
+	n, err := io.CopyN(w, r, remaining)
+	c.done += n
+
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// requestChunk makes a range request for the remaining bytes of the chunk.
+func (d *Downloader) requestChunk(ctx context.Context, url, validator string, c *chunk, file *os.File) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return permanentError{err}
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", c.start+c.done, c.end))
+	req.Header.Set("Accept-Encoding", "identity")
+
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	if d.beforeRequest != nil {
+		d.beforeRequest(req)
+	}
+
+	resp, err := d.do(req, nil)
+	if err != nil {
+		return err
+	}
+
+	if d.afterRequest != nil {
+		d.afterRequest(resp)
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		break
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return BadHTTPCodeError{url, resp.StatusCode}
+	default:
+		// a 200 means the If-Range condition failed: the file has changed
+		return permanentError{BadHTTPCodeError{url, resp.StatusCode}}
+	}
+
+	expected := fmt.Sprintf("bytes %d-%d/", c.start+c.done, c.end)
+	if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, expected) {
+		return permanentError{fmt.Errorf("unexpected Content-Range %q", contentRange)}
+	}
+
+	return c.copyFrom(resp.Body, file)
+}
+
+// hashFile feeds the content of a file to the hashers, when it has not been
+// written sequentially.
+func hashFile(file *os.File, digests *digestVerifier, hasher hash.Hash) error {
+	var writers []io.Writer
+
+	if digests != nil {
+		writers = append(writers, digests.writer())
+	}
+
+	if hasher != nil {
+		writers = append(writers, hasher)
+	}
+
+	if len(writers) == 0 {
+		return nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return fmt.Errorf("while hashing %s: %w", file.Name(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package downloader_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestParallelChunks(t *testing.T) {
+	ctx := context.Background()
+
+	content := make([]byte, 3<<20+123)
+	_, _ = rand.Read(content)
+
+	modTime := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		noRanges   bool
+		failFirst  bool
+		maxSize    int64
+		wantRanges []string
+		wantErr    string
+	}{
+		{
+			name:       "parallel",
+			wantRanges: []string{"", "bytes=1048617-2097233", "bytes=2097234-3145850"},
+		},
+		{
+			name:       "ranges not supported",
+			noRanges:   true,
+			wantRanges: []string{""},
+		},
+		{
+			name:      "a part is retried",
+			failFirst: true,
+			wantRanges: []string{
+				"", "bytes=1048617-2097233", "bytes=2097234-3145850",
+				"bytes=1048617-2097233",
+			},
+		},
+		{
+			name:       "size limit",
+			maxSize:    1 << 20,
+			wantRanges: []string{""},
+			wantErr:    "refusing to download file larger than 1048576 bytes",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				ranges []string
+				failed bool
+			)
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				ranges = append(ranges, r.Header.Get("Range"))
+				fail := tc.failFirst && !failed && r.Header.Get("Range") == "bytes=1048617-2097233"
+				failed = failed || fail
+				mu.Unlock()
+
+				if fail {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				if tc.noRanges {
+					_, _ = w.Write(content)
+					return
+				}
+
+				http.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+
+			dest := filepath.Join(t.TempDir(), "big.bin")
+
+			d := downloader.New().
+				ToFile(dest).
+				WithParallelChunks(3).
+				VerifyHash("sha256", sha256hex(string(content)))
+
+			if tc.maxSize > 0 {
+				d.LimitDownloadSize(tc.maxSize)
+			}
+
+			downloaded, err := d.Download(ctx, ts.URL)
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			// the order of the parallel requests is not deterministic
+			assert.ElementsMatch(t, tc.wantRanges, ranges)
+
+			if tc.wantErr != "" {
+				assert.False(t, downloaded)
+				return
+			}
+
+			assert.True(t, downloaded)
+
+			got, err := os.ReadFile(dest)
+			require.NoError(t, err)
+			assert.Equal(t, content, got)
+		})
+	}
+}
+
+func TestParallelChunksHostPolicy(t *testing.T) {
+	content := make([]byte, 4<<20)
+	_, _ = rand.Read(content)
+
+	modTime := time.Now().Add(-time.Hour)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	for _, downloaders := range []int{1, 2} {
+		t.Run(fmt.Sprintf("%d downloaders", downloaders), func(t *testing.T) {
+			// a deadlock would make the test time out
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			policy := downloader.NewHostPolicy().WithMaxConcurrent(downloaders)
+			dir := t.TempDir()
+
+			var wg sync.WaitGroup
+
+			for i := range downloaders {
+				wg.Go(func() {
+					dest := filepath.Join(dir, fmt.Sprintf("big%d.bin", i))
+
+					downloaded, err := downloader.New().
+						ToFile(dest).
+						WithParallelChunks(4).
+						WithHostPolicy(policy).
+						VerifyHash("sha256", sha256hex(string(content))).
+						Download(ctx, ts.URL)
+					if !assert.NoError(t, err) {
+						return
+					}
+
+					assert.True(t, downloaded)
+
+					got, err := os.ReadFile(dest)
+					if assert.NoError(t, err) {
+						assert.Equal(t, content, got)
+					}
+				})
+			}
+
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package downloader_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+// requestLog records the method and conditional headers of each request.
+type requestLog struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *requestLog) add(r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := r.Method
+	if v := r.Header.Get("If-Modified-Since"); v != "" {
+		entry += " ims=" + v
+	}
+
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		entry += " inm=" + v
+	}
+
+	l.entries = append(l.entries, entry)
+}
+
+func (l *requestLog) reset() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ret := l.entries
+	l.entries = nil
+
+	return ret
+}
+
+func TestConditionalGet(t *testing.T) {
+	ctx := context.Background()
+
+	remoteModTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	lastModified := remoteModTime.Format(http.TimeFormat)
+
+	log := &requestLog{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.add(r)
+
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		http.ServeContent(w, r, "", remoteModTime, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "example.txt")
+
+	d := downloader.New().
+		ToFile(dest).
+		WithLastModified().
+		WithConditionalGet().
+		WithLastModifiedFile(dest + ".last-modified")
+
+	downloaded, err := d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+	assert.Equal(t, []string{"GET"}, log.reset())
+
+	// a single request with the stored remote date
+
+	downloaded, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+	assert.Equal(t, []string{"GET ims=" + lastModified}, log.reset())
+
+	// without conditional GET, the rejected HEAD falls back to GET
+
+	d = downloader.New().
+		ToFile(dest).
+		WithLastModified()
+
+	downloaded, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+
+	entries := log.reset()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "HEAD", entries[0])
+	assert.True(t, strings.HasPrefix(entries[1], "GET ims="), entries[1])
+}
+
+func TestConditionalGetETag(t *testing.T) {
+	ctx := context.Background()
+
+	log := &requestLog{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.add(r)
+
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	d := downloader.New().
+		ToFile(dest).
+		WithETagFile(dest + ".etag")
+
+	downloaded, err := d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+	assert.Equal(t, []string{"HEAD", "GET"}, log.reset())
+
+	downloaded, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+	assert.Equal(t, []string{`HEAD inm="v1"`, `GET inm="v1"`}, log.reset())
+
+	downloaded, err = d.WithConditionalGet().Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+	assert.Equal(t, []string{`GET inm="v1"`}, log.reset())
+}
@@ -0,0 +1,239 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crowdsecurity/go-cs-lib/cstime"
+)
+
+// ByteSize is a size in bytes that can be written with a unit, like "10MB" or "512KiB".
+// SI units (kB, MB, GB, TB) are powers of 1000, binary units (KiB, MiB, GiB, TiB)
+// and single letters (K, M, G, T) are powers of 1024.
+type ByteSize int64
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"k":   1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tib": 1 << 40,
+}
+
+// ParseByteSize parses a number of bytes, with an optional unit.
+func ParseByteSize(input string) (ByteSize, error) {
+	s := strings.TrimSpace(input)
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+
+	number, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+
+	multiplier, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", input, s[i:])
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", input)
+	}
+
+	size := value * multiplier
+	if size > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", input)
+	}
+
+	return ByteSize(size), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by YAML/JSON libs).
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	size, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+
+	*b = size
+
+	return nil
+}
+
+// MarshalText returns the size in bytes.
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(b), 10)), nil
+}
+
+// UnmarshalJSON accepts a number of bytes, or a string with a unit.
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("size must be a number or a string: %s", data)
+	}
+
+	return b.UnmarshalText([]byte(s))
+}
+
+// FileMode is a file mode written as an octal string, like "0640".
+type FileMode os.FileMode
+
+// UnmarshalText implements encoding.TextUnmarshaler (used by YAML/JSON libs).
+func (m *FileMode) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0o"), "0O")
+
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file mode %q: must be an octal number", text)
+	}
+
+	if mode&^uint64(os.ModePerm) != 0 {
+		return fmt.Errorf("invalid file mode %q: only permission bits can be set", text)
+	}
+
+	*m = FileMode(mode)
+
+	return nil
+}
+
+// UnmarshalYAML receives the raw value from goccy/go-yaml, which would otherwise
+// convert an unquoted 0640 to decimal before calling UnmarshalText.
+func (m *FileMode) UnmarshalYAML(raw []byte) error {
+	s := strings.TrimSpace(string(raw))
+
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return fmt.Errorf("invalid file mode %s: %w", s, err)
+		}
+
+		s = unquoted
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		s = s[1 : len(s)-1]
+	}
+
+	return m.UnmarshalText([]byte(s))
+}
+
+// MarshalText returns the mode as an octal string.
+func (m FileMode) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "%04o", uint32(m)), nil
+}
+
+// Config describes a download, and can be read from a YAML or JSON file.
+// Use FromConfig() to create the corresponding Downloader.
+type Config struct {
+	URL         string                  `json:"url"                  yaml:"url"`
+	Destination string                  `json:"destination"          yaml:"destination"`
+	ShelfLife   cstime.DurationWithDays `json:"shelf_life,omitempty" yaml:"shelf_life,omitempty"`
+	MaxSize     ByteSize                `json:"max_size,omitempty"   yaml:"max_size,omitempty"`
+	// Hash is written as "function:value", like "sha256:6ed6e688..."
+	Hash string   `json:"hash,omitempty" yaml:"hash,omitempty"`
+	Mode FileMode `json:"mode,omitempty" yaml:"mode,omitempty"`
+}
+
+// hashLengths are the expected lengths of the hex-encoded values.
+var hashLengths = map[string]int{
+	"sha256": 64,
+	"md5":    32,
+}
+
+// parseHash splits and validates the "function:value" form.
+func parseHash(s string) (string, string, error) {
+	function, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", "", fmt.Errorf("%q must be in the form function:value", s)
+	}
+
+	function = strings.ToLower(function)
+
+	length, ok := hashLengths[function]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported hash function %s", function)
+	}
+
+	if _, err := hex.DecodeString(value); err != nil || len(value) != length {
+		return "", "", fmt.Errorf("%s value must be %d hex characters", function, length)
+	}
+
+	return function, strings.ToLower(value), nil
+}
+
+// FromConfig creates a Downloader from a configuration. All the fields are
+// validated, and the errors are returned together.
+func FromConfig(cfg Config) (*Downloader, error) {
+	var errs []error
+
+	if cfg.URL == "" {
+		errs = append(errs, errors.New("url: must be set"))
+	} else if u, err := url.Parse(cfg.URL); err != nil {
+		errs = append(errs, fmt.Errorf("url: %w", err))
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		errs = append(errs, fmt.Errorf("url: unsupported scheme %q", u.Scheme))
+	}
+
+	if cfg.Destination == "" {
+		errs = append(errs, errors.New("destination: must be set"))
+	}
+
+	if cfg.ShelfLife < 0 {
+		errs = append(errs, errors.New("shelf_life: must not be negative"))
+	}
+
+	if cfg.MaxSize < 0 {
+		errs = append(errs, errors.New("max_size: must not be negative"))
+	}
+
+	d := New().
+		ToFile(cfg.Destination).
+		WithShelfLife(time.Duration(cfg.ShelfLife)).
+		LimitDownloadSize(int64(cfg.MaxSize)).
+		WithMode(os.FileMode(cfg.Mode))
+
+	if cfg.Hash != "" {
+		function, value, err := parseHash(cfg.Hash)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("hash: %w", err))
+		}
+
+		d.VerifyHash(function, value)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid download configuration: %w", errors.Join(errs...))
+	}
+
+	if err := d.ValidateOptions(); err != nil {
+		return nil, fmt.Errorf("invalid download configuration: %w", err)
+	}
+
+	return d, nil
+}
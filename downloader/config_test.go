@@ -0,0 +1,125 @@
+package downloader_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/cstime"
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    downloader.ByteSize
+		wantErr string
+	}{
+		{input: "1024", want: 1024},
+		{input: "10B", want: 10},
+		{input: "10kB", want: 10_000},
+		{input: "10 KiB", want: 10 << 10},
+		{input: "10K", want: 10 << 10},
+		{input: "1.5MB", want: 1_500_000},
+		{input: "2mib", want: 2 << 20},
+		{input: "1G", want: 1 << 30},
+		{input: "10XB", wantErr: `invalid size "10XB": unknown unit "XB"`},
+		{input: "MB", wantErr: `invalid size "MB"`},
+		{input: "", wantErr: `invalid size ""`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := downloader.ParseByteSize(tc.input)
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestConfigUnmarshal(t *testing.T) {
+	want := downloader.Config{
+		URL:         "https://example.com/file.mmdb",
+		Destination: "/var/lib/crowdsec/data/file.mmdb",
+		ShelfLife:   cstime.DurationWithDays(7 * 24 * time.Hour),
+		MaxSize:     100 << 20,
+		Hash:        "sha256:6ed6e688e3e4c916ec310600e10d16883ee7a03c0e4c46e227ae5459902bf029",
+		Mode:        0o640,
+	}
+
+	yamlTests := []string{
+		"mode: 0640",
+		"mode: '0640'",
+		`mode: "0o640"`,
+	}
+
+	for _, mode := range yamlTests {
+		t.Run(mode, func(t *testing.T) {
+			in := `
+url: https://example.com/file.mmdb
+destination: /var/lib/crowdsec/data/file.mmdb
+shelf_life: 7d
+max_size: 100MiB
+hash: sha256:6ed6e688e3e4c916ec310600e10d16883ee7a03c0e4c46e227ae5459902bf029
+` + mode + "\n"
+
+			var cfg downloader.Config
+
+			require.NoError(t, yaml.Unmarshal([]byte(in), &cfg))
+			assert.Equal(t, want, cfg)
+		})
+	}
+
+	in := `{
+		"url": "https://example.com/file.mmdb",
+		"destination": "/var/lib/crowdsec/data/file.mmdb",
+		"shelf_life": "7d",
+		"max_size": 104857600,
+		"hash": "sha256:6ed6e688e3e4c916ec310600e10d16883ee7a03c0e4c46e227ae5459902bf029",
+		"mode": "640"
+	}`
+
+	var cfg downloader.Config
+
+	require.NoError(t, json.Unmarshal([]byte(in), &cfg))
+	assert.Equal(t, want, cfg)
+
+	err := yaml.Unmarshal([]byte("mode: 0999\n"), &cfg)
+	require.ErrorContains(t, err, `invalid file mode "0999": must be an octal number`)
+
+	err = yaml.Unmarshal([]byte("mode: 01777\n"), &cfg)
+	require.ErrorContains(t, err, `invalid file mode "01777": only permission bits can be set`)
+}
+
+func TestFromConfig(t *testing.T) {
+	_, err := downloader.FromConfig(downloader.Config{
+		URL:         "https://example.com/file.mmdb",
+		Destination: "/tmp/file.mmdb",
+		Hash:        "sha256:6ed6e688e3e4c916ec310600e10d16883ee7a03c0e4c46e227ae5459902bf029",
+	})
+	require.NoError(t, err)
+
+	_, err = downloader.FromConfig(downloader.Config{
+		URL:       "ftp://example.com/file.mmdb",
+		ShelfLife: -1,
+		MaxSize:   -1,
+		Hash:      "sha1:abcd",
+	})
+	cstest.RequireErrorMessage(t, err, `invalid download configuration: url: unsupported scheme "ftp"
+destination: must be set
+shelf_life: must not be negative
+max_size: must not be negative
+hash: unsupported hash function sha1`)
+
+	_, err = downloader.FromConfig(downloader.Config{
+		URL:         "https://example.com/file.mmdb",
+		Destination: "/tmp/file.mmdb",
+		Hash:        "sha256:abcd",
+	})
+	cstest.RequireErrorMessage(t, err, `invalid download configuration: hash: sha256 value must be 64 hex characters`)
+}
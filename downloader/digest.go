@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrNoDigestHeader is returned when digest headers are required but the server
+// did not send any that can be verified.
+var ErrNoDigestHeader = errors.New("no supported digest header in response")
+
+// digestAlgorithms maps the lowercase names used in Digest and Repr-Digest headers.
+var digestAlgorithms = map[string]crypto.Hash{
+	"md5":     crypto.MD5,
+	"sha-256": crypto.SHA256,
+	"sha-512": crypto.SHA512,
+}
+
+// expectedDigest is a checksum announced by the server in a response header.
+type expectedDigest struct {
+	header    string
+	algorithm string
+	value     []byte
+}
+
+// VerifyDigestHeaders validates the downloaded content against the checksums sent
+// by the server in the Digest (RFC 3230), Repr-Digest (RFC 9530) or Content-MD5 headers.
+// The checksums apply to the content as transferred, before any decompression.
+// If required is true, the download fails with ErrNoDigestHeader when the server
+// does not provide a supported digest.
+func (d *Downloader) VerifyDigestHeaders(required bool) *Downloader {
+	d.verifyDigest = true
+	d.requireDigest = required
+
+	return d
+}
+
+// splitDigestList splits a comma-separated list of "algorithm=value" items.
+// Parameters (after ';') are discarded.
+func splitDigestList(value string) [][2]string {
+	var ret [][2]string
+
+	for item := range strings.SplitSeq(value, ",") {
+		item, _, _ = strings.Cut(item, ";")
+
+		alg, val, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			continue
+		}
+
+		ret = append(ret, [2]string{strings.ToLower(strings.TrimSpace(alg)), strings.TrimSpace(val)})
+	}
+
+	return ret
+}
+
+// parseDigestHeaders collects the checksums with a supported algorithm.
+func parseDigestHeaders(header http.Header) ([]expectedDigest, error) {
+	var ret []expectedDigest
+
+	decode := func(name, alg, value string) error {
+		if _, ok := digestAlgorithms[alg]; !ok {
+			return nil
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("malformed %s header: %w", name, err)
+		}
+
+		ret = append(ret, expectedDigest{header: name, algorithm: alg, value: raw})
+
+		return nil
+	}
+
+	for _, v := range header.Values("Repr-Digest") {
+		for _, item := range splitDigestList(v) {
+			// structured field byte sequence, :base64:
+			value, ok := strings.CutPrefix(item[1], ":")
+			if ok {
+				value, ok = strings.CutSuffix(value, ":")
+			}
+
+			if !ok {
+				return nil, fmt.Errorf("malformed Repr-Digest header: %q", v)
+			}
+
+			if err := decode("Repr-Digest", item[0], value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, v := range header.Values("Digest") {
+		for _, item := range splitDigestList(v) {
+			if err := decode("Digest", item[0], item[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, v := range header.Values("Content-MD5") {
+		if err := decode("Content-MD5", "md5", strings.TrimSpace(v)); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// digestVerifier hashes the response body and compares it with the digest headers.
+type digestVerifier struct {
+	expected []expectedDigest
+	hashers  map[string]hash.Hash
+}
+
+// newDigestVerifier returns nil if there is nothing to verify.
+func (d *Downloader) newDigestVerifier(resp *http.Response) (*digestVerifier, error) {
+	if !d.verifyDigest {
+		return nil, nil
+	}
+
+	expected, err := parseDigestHeaders(resp.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(expected) == 0 {
+		if d.requireDigest {
+			return nil, ErrNoDigestHeader
+		}
+
+		d.logger.Debug("No digest header to verify")
+
+		return nil, nil
+	}
+
+	v := &digestVerifier{
+		expected: expected,
+		hashers:  make(map[string]hash.Hash),
+	}
+
+	for _, e := range expected {
+		if _, ok := v.hashers[e.algorithm]; !ok {
+			v.hashers[e.algorithm] = digestAlgorithms[e.algorithm].New()
+		}
+	}
+
+	return v, nil
+}
+
+// writer returns a writer that feeds all the hashers.
+func (v *digestVerifier) writer() io.Writer {
+	writers := make([]io.Writer, 0, len(v.hashers))
+	for _, h := range v.hashers {
+		writers = append(writers, h)
+	}
+
+	return io.MultiWriter(writers...)
+}
+
+// wrap returns a reader that feeds all the bytes read from r to the hashers.
+func (v *digestVerifier) wrap(r io.Reader) io.Reader {
+	return io.TeeReader(r, v.writer())
+}
+
+// verify must be called after the body has been read entirely.
+func (v *digestVerifier) verify() error {
+	for _, e := range v.expected {
+		got := v.hashers[e.algorithm].Sum(nil)
+		if !bytes.Equal(got, e.value) {
+			return HashMismatchError{
+				Expected: e.algorithm + ":" + hex.EncodeToString(e.value),
+				Got:      e.algorithm + ":" + hex.EncodeToString(got),
+			}
+		}
+	}
+
+	return nil
+}
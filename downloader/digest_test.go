@@ -0,0 +1,156 @@
+package downloader_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5" //nolint:gosec // testing Content-MD5
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestVerifyDigestHeaders(t *testing.T) {
+	ctx := context.Background()
+
+	content := []byte("content")
+
+	sha256sum := sha256.Sum256(content)
+	sha512sum := sha512.Sum512(content)
+	md5sum := md5.Sum(content) //nolint:gosec
+	b64 := base64.StdEncoding.EncodeToString
+
+	var gzipped bytes.Buffer
+
+	gz := gzip.NewWriter(&gzipped)
+	_, _ = gz.Write(content)
+	_ = gz.Close()
+
+	gzsum := sha256.Sum256(gzipped.Bytes())
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		gzip     bool
+		required bool
+		wantErr  string
+	}{
+		{
+			name:    "repr-digest",
+			headers: map[string]string{"Repr-Digest": "sha-256=:" + b64(sha256sum[:]) + ":"},
+		},
+		{
+			name:    "repr-digest, multiple algorithms",
+			headers: map[string]string{"Repr-Digest": "sha-512=:" + b64(sha512sum[:]) + ":, sha-256=:" + b64(sha256sum[:]) + ":"},
+		},
+		{
+			name:    "repr-digest mismatch",
+			headers: map[string]string{"Repr-Digest": "sha-256=:" + b64(sha512sum[:32]) + ":"},
+			wantErr: "hash mismatch: expected sha-256:",
+		},
+		{
+			name:    "repr-digest, malformed",
+			headers: map[string]string{"Repr-Digest": "sha-256=" + b64(sha256sum[:])},
+			wantErr: "malformed Repr-Digest header",
+		},
+		{
+			name:    "digest",
+			headers: map[string]string{"Digest": "SHA-256=" + b64(sha256sum[:])},
+		},
+		{
+			name:    "digest mismatch",
+			headers: map[string]string{"Digest": "MD5=" + b64(sha256sum[:16])},
+			wantErr: "hash mismatch: expected md5:",
+		},
+		{
+			name:    "content-md5",
+			headers: map[string]string{"Content-MD5": b64(md5sum[:])},
+		},
+		{
+			name:    "digest of the compressed body",
+			headers: map[string]string{"Repr-Digest": "sha-256=:" + b64(gzsum[:]) + ":"},
+			gzip:    true,
+		},
+		{
+			name:    "unsupported algorithm is ignored",
+			headers: map[string]string{"Digest": "UNIXsum=1234"},
+		},
+		{
+			name:     "no digest, required",
+			headers:  map[string]string{"Digest": "UNIXsum=1234"},
+			required: true,
+			wantErr:  "no supported digest header in response",
+		},
+		{
+			name:     "no digest, optional",
+			required: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				for k, v := range tc.headers {
+					w.Header().Set(k, v)
+				}
+
+				if tc.gzip {
+					w.Header().Set("Content-Encoding", "gzip")
+					_, _ = w.Write(gzipped.Bytes())
+
+					return
+				}
+
+				_, _ = w.Write(content)
+			}))
+			defer ts.Close()
+
+			dest := filepath.Join(t.TempDir(), "example.txt")
+
+			downloaded, err := downloader.New().
+				ToFile(dest).
+				VerifyDigestHeaders(tc.required).
+				Download(ctx, ts.URL)
+			cstest.RequireErrorContains(t, err, tc.wantErr)
+
+			if tc.wantErr != "" {
+				assert.False(t, downloaded)
+				assert.NoFileExists(t, dest)
+
+				return
+			}
+
+			assert.True(t, downloaded)
+			assert.FileExists(t, dest)
+		})
+	}
+}
+
+func TestVerifyDigestHeadersMismatchType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(make([]byte, 16)))
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	_, err := downloader.New().
+		ToFile(filepath.Join(t.TempDir(), "example.txt")).
+		VerifyDigestHeaders(false).
+		Download(context.Background(), ts.URL)
+
+	var mismatch downloader.HashMismatchError
+
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, "md5:00000000000000000000000000000000", mismatch.Expected)
+}
@@ -61,24 +61,13 @@ func nullLogger() *logrus.Entry {
 
 // SHA256 returns the (hex-encoded) hash of the file if possible, empty string otherwise.
 func SHA256(path string) (string, error) {
-	file, err := os.Open(path)
-
-	switch {
-	case os.IsNotExist(err):
+	sum, err := hashContent(path, crypto.SHA256.New())
+	if os.IsNotExist(err) {
 		// first time download
 		return "", nil
-	case err != nil:
-		return "", err
-	}
-
-	defer file.Close()
-
-	h := crypto.SHA256.New()
-	if _, err := io.Copy(h, file); err != nil {
-		return "", err
 	}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return sum, err
 }
 
 // Downloader fetches a file from a URL to a destination path, with various options.
@@ -87,19 +76,38 @@ type Downloader struct {
 	logger             *logrus.Entry
 	etagFn             *func(string) (string, error)
 	etagPath           string
+	lastModifiedPath   string
+	hashPath           string
+	checksumURL        string
 	httpClient         *http.Client
+	cache              *Cache
+	hostPolicy         *HostPolicy
+	breaker            *CircuitBreaker
+	staging            bool // set by Transaction, the file is not renamed
+	redirectHosts      []string
 	destPath           string
 	verifyHashFunction string
 	verifyHashValue    string
 	maxSize            int64
 	shelfLife          time.Duration // update if local file is older than this
+	maxStale           time.Duration // use local file on error if not older than this
 	mode               os.FileMode
 	makeDirs           bool
 	ifModifiedSince    bool
 	lastModified       bool
+	conditionalGet     bool
+	integrityCheck     bool
 	compareContent     bool
 	beforeRequest      func(*http.Request)
 	afterRequest       func(*http.Response)
+	chunks             int
+	maxWireSize        int64
+	maxDecodedSize     int64
+	maxRatio           float64
+	maxRedirects       int
+	verifyDigest       bool
+	requireDigest      bool
+	forbidDowngrade    bool
 }
 
 // New creates a new downloader for the given URL.
@@ -107,8 +115,9 @@ func New() *Downloader {
 	logger := nullLogger()
 
 	return &Downloader{
-		logger:     logger,
-		httpClient: http.DefaultClient,
+		logger:       logger,
+		httpClient:   http.DefaultClient,
+		maxRedirects: -1,
 	}
 }
 
@@ -173,6 +182,16 @@ func (d *Downloader) WithLastModified() *Downloader {
 	return d
 }
 
+// WithConditionalGet skips the HEAD request of WithLastModified() and WithETag*(),
+// and relies on a single conditional GET instead: the server answers 304 if the
+// file has not changed. With WithLastModified(), the "If-Modified-Since" header
+// is the remote Last-Modified value stored by WithLastModifiedFile(), or the
+// local modification time. The shelf life is not used in this mode.
+func (d *Downloader) WithConditionalGet() *Downloader {
+	d.conditionalGet = true
+	return d
+}
+
 // WithShelfLife sets the duration after which a file is considered stale, if it has no
 // "Last-Modified" header. If unset, the file will be considered stale by default.
 func (d *Downloader) WithShelfLife(shelfLife time.Duration) *Downloader {
@@ -195,7 +214,8 @@ func (d *Downloader) WithHTTPClient(client *http.Client) *Downloader {
 
 // LimitDownloadSize sets the maximum size of the downloaded file,
 // by checking the Content-Length header and monitoring the size again
-// while uncompressing the payload.
+// while uncompressing the payload. LimitWireSize() and LimitDecodedSize()
+// can set a different value for each step.
 func (d *Downloader) LimitDownloadSize(size int64) *Downloader {
 	d.maxSize = size
 	return d
@@ -230,34 +250,22 @@ func (d *Downloader) getDestInfo() (time.Time, fs.FileMode) {
 	return dstInfo.ModTime(), dstInfo.Mode().Perm()
 }
 
+// errHeadRejected is returned by isLocalFresh when the server does not support HEAD requests.
+var errHeadRejected = errors.New("HEAD request rejected")
+
 // isLocalFresh returns whether we can skip the download, according to mtime and etag values, when set.
 // If neither is set, the file is considered stale after the shelf life period.
-func (d *Downloader) isLocalFresh(ctx context.Context, url string, modTime time.Time, etag string) (bool, error) {
+func (d *Downloader) isLocalFresh(ctx context.Context, url string, modTime time.Time, etag *boundETag) (bool, error) {
 	if !d.lastModified && d.etagFn == nil {
 		return false, nil
 	}
 
-	localIsOld := true
-
-	if d.shelfLife != 0 {
-		localIsOld = modTime.Add(d.shelfLife).Before(time.Now())
-	}
-
 	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, http.NoBody)
 	if err != nil {
 		return false, fmt.Errorf("failed to create HEAD request for %s: %w", url, err)
 	}
 
-	if etag != "" {
-		req.Header.Add("If-None-Match", etag)
-	}
-
-	client := d.httpClient
-	if client == nil {
-		client = http.DefaultClient
-	}
-
-	resp, err := client.Do(req)
+	resp, err := d.do(req, etag)
 	if err != nil {
 		return false, fmt.Errorf("failed to make HEAD request for %s: %w", url, err)
 	}
@@ -269,6 +277,9 @@ func (d *Downloader) isLocalFresh(ctx context.Context, url string, modTime time.
 		return true, nil
 	case http.StatusOK:
 		break
+	case http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		d.logger.Debugf("HEAD request rejected with %d, falling back to conditional GET", resp.StatusCode)
+		return false, errHeadRejected
 	default:
 		return false, BadHTTPCodeError{url, resp.StatusCode}
 	}
@@ -277,18 +288,22 @@ func (d *Downloader) isLocalFresh(ctx context.Context, url string, modTime time.
 		return false, nil
 	}
 
-	remoteLastModified := resp.Header.Get("Last-Modified")
-	if remoteLastModified == "" {
-		if !localIsOld {
-			d.logger.Debugf("No last modified header, but local file is not old: %s",
-				d.destPath)
+	fresh, reason := d.compareLastModified(resp.Header.Get("Last-Modified"), modTime)
+	d.logger.Debugf("%s: %s", d.destPath, reason)
 
-			return true, nil
-		}
+	return fresh, nil
+}
 
-		d.logger.Debugf("No last modified header: %s", d.destPath)
+// compareLastModified returns whether the local file is fresh according to the
+// remote Last-Modified header, or the shelf life if there is no such header.
+// The reason explains the verdict.
+func (d *Downloader) compareLastModified(remoteLastModified string, modTime time.Time) (bool, string) {
+	if remoteLastModified == "" {
+		if d.shelfLife != 0 && !modTime.Add(d.shelfLife).Before(time.Now()) {
+			return true, "no Last-Modified header, but local file is not old"
+		}
 
-		return false, nil
+		return false, "no Last-Modified header"
 	}
 
 	lastAvailable, err := time.Parse(http.TimeFormat, remoteLastModified)
@@ -297,13 +312,10 @@ func (d *Downloader) isLocalFresh(ctx context.Context, url string, modTime time.
 	}
 
 	if modTime.After(lastAvailable) {
-		d.logger.Debugf("Local file is newer than remote: %s (%s vs %s)",
-			d.destPath, modTime, lastAvailable)
-
-		return true, nil
+		return true, fmt.Sprintf("local file is newer than remote (%s vs %s)", modTime, lastAvailable)
 	}
 
-	return false, nil
+	return false, fmt.Sprintf("remote file is newer than local (%s vs %s)", lastAvailable, modTime)
 }
 
 // VerifyHash sets the hash function and value to check the downloaded file against.
@@ -314,8 +326,8 @@ func (d *Downloader) VerifyHash(hashFunction, hashValue string) *Downloader {
 	return d
 }
 
-func (d *Downloader) selectHashFunction() (hash.Hash, error) {
-	switch d.verifyHashFunction {
+func selectHashFunction(hashFunction string) (hash.Hash, error) {
+	switch hashFunction {
 	case "sha256":
 		return crypto.SHA256.New(), nil
 	case "md5":
@@ -323,7 +335,7 @@ func (d *Downloader) selectHashFunction() (hash.Hash, error) {
 	case "":
 		return nil, nil
 	default:
-		return nil, fmt.Errorf("unsupported hash function %s", d.verifyHashFunction)
+		return nil, fmt.Errorf("unsupported hash function %s", hashFunction)
 	}
 }
 
@@ -339,6 +351,14 @@ func (d *Downloader) ValidateOptions() error {
 		return errors.New("shelfLife must not be negative")
 	}
 
+	if d.maxSize < 0 || d.maxWireSize < 0 || d.maxDecodedSize < 0 || d.maxRatio < 0 {
+		return errors.New("size limits must not be negative")
+	}
+
+	if d.maxStale < 0 {
+		return errors.New("maxStale must not be negative")
+	}
+
 	if d.verifyHashFunction != "" && d.verifyHashValue == "" {
 		return errors.New("hash value must be set when hash function is set")
 	}
@@ -347,6 +367,10 @@ func (d *Downloader) ValidateOptions() error {
 		return errors.New("hash function must be set when hash value is set")
 	}
 
+	if d.integrityCheck && d.verifyHashValue == "" && d.hashPath == "" && d.checksumURL == "" {
+		return errors.New("integrity check requires a hash value or a hash file")
+	}
+
 	cacheConditions := 0
 
 	if d.lastModified {
@@ -394,7 +418,7 @@ func (d *Downloader) WithETagFile(etagPath string) *Downloader {
 			return "", nil
 		}
 
-		fin, err := os.ReadFile(etagPath)
+		etag, _, err := readETagFile(etagPath)
 
 		switch {
 		case os.IsNotExist(err):
@@ -403,7 +427,7 @@ func (d *Downloader) WithETagFile(etagPath string) *Downloader {
 			return "", fmt.Errorf("can't read etag file %s: %w", etagPath, err)
 		}
 
-		return string(fin), nil
+		return etag, nil
 	}
 
 	d.etagFn = &callback
@@ -411,7 +435,67 @@ func (d *Downloader) WithETagFile(etagPath string) *Downloader {
 	return d
 }
 
+// WithLastModifiedFile sets the path to a file where the remote "Last-Modified" header
+// will be stored, to be sent back as "If-Modified-Since" by WithConditionalGet().
+func (d *Downloader) WithLastModifiedFile(lastModifiedPath string) *Downloader {
+	d.lastModifiedPath = lastModifiedPath
+	return d
+}
+
+// storedLastModified returns the Last-Modified value stored with the destination file, if any.
+func (d *Downloader) storedLastModified() string {
+	if d.lastModifiedPath == "" || !isAtLeastAsRecent(d.lastModifiedPath, d.destPath) {
+		return ""
+	}
+
+	content, err := os.ReadFile(d.lastModifiedPath)
+	if err != nil {
+		d.logger.Warnf("Failed to read last modified file %s: %s", d.lastModifiedPath, err)
+		return ""
+	}
+
+	return string(bytes.TrimSpace(content))
+}
+
+// storeLastModified writes the content of a Last-Modified header to the file.
+func storeLastModified(resp *http.Response, lastModifiedPath string, logger *logrus.Entry) {
+	if lastModifiedPath == "" {
+		return
+	}
+
+	lastModified := resp.Header.Get("Last-Modified")
+	if lastModified == "" {
+		logger.Debug("No Last-Modified header")
+		return
+	}
+
+	if err := os.WriteFile(lastModifiedPath, []byte(lastModified), 0o600); err != nil {
+		logger.Errorf("Failed to write Last-Modified to %s: %s", lastModifiedPath, err)
+	}
+}
+
+// modifiedSince returns the value of the "If-Modified-Since" header, if one must be sent.
+func (d *Downloader) modifiedSince(destModTime time.Time, conditionalGet bool) string {
+	if destModTime.IsZero() {
+		return ""
+	}
+
+	switch {
+	case d.ifModifiedSince:
+		return destModTime.UTC().Format(http.TimeFormat)
+	case d.lastModified && conditionalGet:
+		if stored := d.storedLastModified(); stored != "" {
+			return stored
+		}
+
+		return destModTime.UTC().Format(http.TimeFormat)
+	}
+
+	return ""
+}
+
 // storeETag writes the content of an ETag header to the file.
+// After a redirect, the final URL is stored too.
 func storeETag(resp *http.Response, etagPath string, logger *logrus.Entry) {
 	if etagPath == "" {
 		return
@@ -423,6 +507,10 @@ func storeETag(resp *http.Response, etagPath string, logger *logrus.Entry) {
 		return
 	}
 
+	if redirectChain(resp) != nil {
+		etag += "\n" + resp.Request.URL.String()
+	}
+
 	if err := os.WriteFile(etagPath, []byte(etag), 0o600); err != nil {
 		logger.Errorf("Failed to write ETag to %s: %s", etagPath, err)
 	}
@@ -501,29 +589,83 @@ func (d *Downloader) getETag(destModTime time.Time) (string, error) {
 }
 
 // Download downloads the file from the URL to the destination path.
-// Returns true if the file was downloaded, false if it was already up to date.
+// Returns true if the file was downloaded, false if it was already up to date
+// (or a stale copy is used, see WithStaleOnError()).
 func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
-	// only one of etagfn, ifmod, lastmod
-	if err := d.ValidateOptions(); err != nil {
-		return false, fmt.Errorf("downloader options: %w", err)
+	result, err := d.DownloadWithResult(ctx, url)
+	if err != nil {
+		return false, err
 	}
 
+	return result.Outcome == OutcomeDownloaded, nil
+}
+
+// download does the actual work for DownloadWithResult(), options must have been validated.
+func (d *Downloader) download(ctx context.Context, url string, result *Result) (bool, error) {
 	d.logger.Debugf("Checking %s", d.destPath)
 
+	// the hash from the checksum file replaces the one from the options
+	hashFunction, hashValue, checksum := d.verifyHashFunction, d.verifyHashValue, ""
+
+	if d.checksumURL != "" {
+		sum, err := d.fetchChecksum(ctx, url)
+		if err != nil {
+			return false, fmt.Errorf("while fetching checksum for %s: %w", url, err)
+		}
+
+		hashFunction, hashValue, checksum = "sha256", sum, sum
+	}
+
 	destModTime, destFileMode := d.getDestInfo()
 
+	// update the file mode from the options, or the pre-existing file mode, if any
+
+	fileMode := d.mode
+	if fileMode == 0 && destFileMode != 0 {
+		fileMode = destFileMode
+	}
+
+	if d.cache != nil && hashFunction == "sha256" {
+		if d.hasContent(hashValue) {
+			d.logger.Debugf("%s already has the expected content", d.destPath)
+			d.storeHash(d.destPath)
+
+			return false, nil
+		}
+
+		if installed, err := d.installFromCache(hashValue, fileMode, nil, result); installed || err != nil {
+			return installed, err
+		}
+	}
+
+	// a corrupted file is handled like a missing one: no validator is sent
+	localIntact := destModTime.IsZero() || d.isLocalIntact(checksum)
+	if !localIntact {
+		destModTime = time.Time{}
+	}
+
 	etag, err := d.getETag(destModTime)
 	if err != nil {
 		d.logger.Warnf("Failed to get etag: %s", err)
 	}
 
-	uptodate, err := d.isLocalFresh(ctx, url, destModTime, etag)
-	if err != nil {
-		d.logger.Warnf("Failed to check last modified: %s", err)
-	}
+	boundETag := d.bindETag(url, etag)
 
-	if uptodate {
-		return false, nil
+	conditionalGet := d.conditionalGet
+
+	if !conditionalGet && localIntact {
+		uptodate, err := d.isLocalFresh(ctx, url, destModTime, boundETag)
+
+		switch {
+		case errors.Is(err, errHeadRejected):
+			conditionalGet = true
+		case err != nil:
+			d.logger.Warnf("Failed to check last modified: %s", err)
+		}
+
+		if uptodate {
+			return false, nil
+		}
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
@@ -533,13 +675,12 @@ func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
 
 	req.Header.Add("Accept-Encoding", "gzip")
 
-	if d.ifModifiedSince && (destModTime != time.Time{}) {
-		req.Header.Add("If-Modified-Since", destModTime.Format(http.TimeFormat))
-		d.logger.Trace("If-Modified-Since: ", destModTime)
+	if since := d.modifiedSince(destModTime, conditionalGet); since != "" {
+		req.Header.Add("If-Modified-Since", since)
+		d.logger.Trace("If-Modified-Since: ", since)
 	}
 
 	if etag != "" {
-		req.Header.Add("If-None-Match", etag)
 		d.logger.Trace("If-None-Match: ", etag)
 	}
 
@@ -547,11 +688,13 @@ func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
 		d.beforeRequest(req)
 	}
 
-	resp, err := d.httpClient.Do(req)
+	resp, err := d.do(req, boundETag)
 	if err != nil {
 		return false, fmt.Errorf("failed http request for %s: %w", url, err)
 	}
 
+	result.Redirects = redirectChain(resp)
+
 	if d.afterRequest != nil {
 		d.afterRequest(resp)
 	}
@@ -570,25 +713,42 @@ func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
 		return false, BadHTTPCodeError{url, resp.StatusCode}
 	}
 
-	if d.enforceMaxSize(resp) != nil {
-		return false, err
-	}
+	if d.cache != nil {
+		if sum := d.cache.lookupValidator(resp.Request.URL.String(), validator(resp)); sum != "" {
+			if hashFunction != "sha256" || sum == hashValue {
+				upToDate := d.hasContent(sum)
 
-	reader := resp.Body
+				path := d.cache.blobPath(sum)
+				if upToDate {
+					path = d.destPath
+				}
 
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzipReader, err := gzip.NewReader(reader)
-		if err != nil {
-			return false, fmt.Errorf("failed to create gzip reader: %w", err)
-		}
+				err := d.verifyCachedContent(path, resp, hashFunction, hashValue)
 
-		defer gzipReader.Close()
+				switch {
+				case err != nil:
+					d.logger.Debugf("Not using the cached content of %s: %s", url, err)
+				case upToDate:
+					d.logger.Debugf("%s already has the content of %s", d.destPath, url)
+					d.storeMetadata(resp)
 
-		reader = gzipReader
+					return false, nil
+				default:
+					if installed, err := d.installFromCache(sum, fileMode, resp, result); installed || err != nil {
+						return installed, err
+					}
+				}
+			}
+		}
 	}
 
-	if d.maxSize > 0 {
-		reader = NewLimitedReader(reader, d.maxSize)
+	if err = d.enforceMaxSize(resp); err != nil {
+		return false, err
+	}
+
+	digests, err := d.newDigestVerifier(resp)
+	if err != nil {
+		return false, fmt.Errorf("while checking digest of %s: %w", url, err)
 	}
 
 	destDir, destName := filepath.Split(d.destPath)
@@ -608,15 +768,11 @@ func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
 
 	defer func() {
 		_ = tmpFile.Close()
-		_ = os.Remove(tmpFileName)
-	}()
-
-	// update the file mode from the options, or the pre-existing file mode, if any
 
-	fileMode := d.mode
-	if fileMode == 0 && destFileMode != 0 {
-		fileMode = destFileMode
-	}
+		if result.staged == nil {
+			_ = os.Remove(tmpFileName)
+		}
+	}()
 
 	if fileMode != 0 {
 		if err = tmpFile.Chmod(fileMode); err != nil {
@@ -624,33 +780,38 @@ func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
 		}
 	}
 
-	hasher, err := d.selectHashFunction()
+	hasher, err := selectHashFunction(hashFunction)
 	if err != nil {
 		return false, fmt.Errorf("while hashing %s: %w", d.destPath, err)
 	}
 
-	writers := []io.Writer{tmpFile}
-	if hasher != nil {
-		writers = append(writers, hasher)
-	}
+	var written int64
 
-	multiWriter := io.MultiWriter(writers...)
-
-	written, err := io.Copy(multiWriter, reader)
+	if chunks := d.chunkCount(resp); chunks > 1 {
+		written, err = d.downloadChunks(ctx, url, resp, tmpFile, chunks)
+		if err == nil {
+			err = hashFile(tmpFile, digests, hasher)
+		}
+	} else {
+		written, err = d.copyBody(resp, tmpFile, digests, hasher)
+	}
 
-	switch {
-	case errors.Is(err, ErrSizeLimitExceeded):
-		return false, fmt.Errorf("download of %s halted: limit of %d bytes exceeded", tmpFileName, d.maxSize)
-	case err != nil:
-		return false, fmt.Errorf("while writing to %s: %w", tmpFileName, err)
+	if err != nil {
+		return false, err
 	}
 
 	d.logger.Debugf("Written %d bytes to %s", written, d.destPath)
 
+	if digests != nil {
+		if err = digests.verify(); err != nil {
+			return false, err
+		}
+	}
+
 	if hasher != nil {
 		got := hex.EncodeToString(hasher.Sum(nil))
-		if got != d.verifyHashValue {
-			return false, HashMismatchError{Expected: d.verifyHashValue, Got: got}
+		if got != hashValue {
+			return false, HashMismatchError{Expected: hashValue, Got: got}
 		}
 	}
 
@@ -662,7 +823,7 @@ func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
 		return false, err
 	}
 
-	storeETag(resp, d.etagPath, d.logger)
+	d.storeInCache(tmpFileName, resp)
 
 	if d.compareContent {
 		same, err := compareFiles(d.destPath, tmpFileName)
@@ -672,6 +833,8 @@ func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
 
 		if same {
 			d.logger.Debugf("Content is the same, not replacing %s", d.destPath)
+			d.storeMetadata(resp)
+
 			// still, we need to update the modification time
 			now := time.Now()
 			if err = os.Chtimes(d.destPath, now, now); err != nil {
@@ -682,29 +845,121 @@ func (d *Downloader) Download(ctx context.Context, url string) (bool, error) {
 		}
 	}
 
+	if d.staging {
+		result.staged = &stagedFile{path: tmpFileName, finish: func() { d.storeMetadata(resp) }}
+		return true, nil
+	}
+
+	if err = replaceFile(tmpFileName, d.destPath); err != nil {
+		d.logger.Errorf("Failed to replace destination file: %s", err)
+		return false, err
+	}
+
+	d.storeMetadata(resp)
+
+	return true, nil
+}
+
+// storeMetadata writes the ETag, Last-Modified and hash files of the destination,
+// once it's in place. Without a response (the file was installed from the cache
+// by hash), the validators of the previous content are removed.
+func (d *Downloader) storeMetadata(resp *http.Response) {
+	if resp != nil {
+		storeETag(resp, d.etagPath, d.logger)
+		storeLastModified(resp, d.lastModifiedPath, d.logger)
+	} else {
+		for _, path := range []string{d.etagPath, d.lastModifiedPath} {
+			if path == "" {
+				continue
+			}
+
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				d.logger.Errorf("Failed to remove %s: %s", path, err)
+			}
+		}
+	}
+
+	d.storeHash(d.destPath)
+}
+
+// replaceFile renames a file, overwriting the destination if it exists.
+func replaceFile(src, dst string) error {
 	if runtime.GOOS == "windows" {
 		// On Windows, rename will fail if the destination file already exists
 		// so we remove it first.
-		err = os.Remove(d.destPath)
+		err := os.Remove(dst)
 
 		switch {
 		case errors.Is(err, fs.ErrNotExist):
 			break
 		case err != nil:
-			d.logger.Errorf("Failed to remove destination file before renaming: %s", err)
-			return false, err
+			return fmt.Errorf("failed to remove destination file before renaming: %w", err)
 		}
 	}
 
-	if err = os.Rename(tmpFileName, d.destPath); err != nil {
-		return false, err
+	return os.Rename(src, dst)
+}
+
+// copyBody writes the response body to the file, uncompressing it if needed,
+// and feeds the hashers.
+func (d *Downloader) copyBody(resp *http.Response, file *os.File, digests *digestVerifier, hasher hash.Hash) (int64, error) {
+	wire := &wireReader{r: resp.Body, limit: d.wireLimit()}
+
+	var reader io.Reader = wire
+
+	if digests != nil {
+		reader = digests.wrap(reader)
 	}
 
-	return true, nil
+	rawReader := reader
+
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+
+		defer gzipReader.Close()
+
+		reader = gzipReader
+	}
+
+	reader = &decodedReader{r: reader, wire: wire, limit: d.decodedLimit(), maxRatio: d.maxRatio}
+
+	writers := []io.Writer{file}
+	if hasher != nil {
+		writers = append(writers, hasher)
+	}
+
+	multiWriter := io.MultiWriter(writers...)
+
+	written, err := io.Copy(multiWriter, reader)
+
+	var ratioErr CompressionRatioError
+
+	switch {
+	case errors.Is(err, ErrSizeLimitExceeded), errors.As(err, &ratioErr):
+		return 0, fmt.Errorf("download of %s halted: %w", file.Name(), err)
+	case err != nil:
+		return 0, fmt.Errorf("while writing to %s: %w", file.Name(), err)
+	}
+
+	if digests != nil {
+		// consume what the decompressor may have left, the digest covers the whole body
+		if _, err = io.Copy(io.Discard, rawReader); err != nil {
+			return 0, fmt.Errorf("while reading %s: %w", resp.Request.URL, err)
+		}
+	}
+
+	return written, nil
 }
 
+// enforceMaxSize checks the Content-Length header against the size limits.
 func (d *Downloader) enforceMaxSize(resp *http.Response) error {
-	if d.maxSize == 0 {
+	wireLimit := d.wireLimit()
+	decodedLimit := d.decodedLimit()
+
+	if wireLimit == 0 && decodedLimit == 0 {
 		return nil
 	}
 
@@ -718,9 +973,14 @@ func (d *Downloader) enforceMaxSize(resp *http.Response) error {
 		d.logger.Warnf("failed to parse Content-Length header: %s", err)
 	}
 
-	if d.maxSize > 0 && contentLength > d.maxSize {
-		return fmt.Errorf("refusing to download file larger than %d bytes: Content-Length=%d",
-			d.maxSize, contentLength)
+	if wireLimit > 0 && contentLength > wireLimit {
+		return WireSizeError{Limit: wireLimit, ContentLength: contentLength}
+	}
+
+	// without compression, we know the final size too
+	encoding := resp.Header.Get("Content-Encoding")
+	if (encoding == "" || encoding == "identity") && decodedLimit > 0 && contentLength > decodedLimit {
+		return DecodedSizeError{Limit: decodedLimit}
 	}
 
 	return nil
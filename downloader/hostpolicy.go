@@ -0,0 +1,226 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHostPause = time.Minute
+	defaultMaxPause  = time.Hour
+)
+
+// HostPolicy limits the requests sent to each host. It can be shared by several
+// Downloaders (with WithHostPolicy), and is safe for concurrent use.
+//
+// A host that responds with 429 Too Many Requests or 503 Service Unavailable
+// is paused for the duration of the Retry-After header, if present.
+type HostPolicy struct {
+	mu            sync.Mutex
+	hosts         map[string]*hostState
+	maxConcurrent int
+	minInterval   time.Duration
+	defaultPause  time.Duration
+	maxPause      time.Duration
+}
+
+type hostState struct {
+	// semaphore for concurrent requests, nil if not limited
+	slots chan struct{}
+	// no request can start before this time
+	next time.Time
+	// set after a 429/503
+	pausedUntil time.Time
+}
+
+// NewHostPolicy creates a policy without limits, and a pause of one minute
+// after a 429/503 response without Retry-After.
+func NewHostPolicy() *HostPolicy {
+	return &HostPolicy{
+		hosts:        make(map[string]*hostState),
+		defaultPause: defaultHostPause,
+		maxPause:     defaultMaxPause,
+	}
+}
+
+// WithMaxConcurrent sets the maximum number of requests in progress for each host.
+// A request is in progress until its response body is closed.
+func (p *HostPolicy) WithMaxConcurrent(n int) *HostPolicy {
+	p.maxConcurrent = n
+	return p
+}
+
+// WithMinInterval sets the minimum time between the start of two requests to the same host.
+func (p *HostPolicy) WithMinInterval(interval time.Duration) *HostPolicy {
+	p.minInterval = interval
+	return p
+}
+
+// WithPause sets how long a host is paused after a 429/503 response: defaultPause when
+// the server does not send Retry-After, and no more than maxPause in any case.
+func (p *HostPolicy) WithPause(defaultPause, maxPause time.Duration) *HostPolicy {
+	p.defaultPause = defaultPause
+	p.maxPause = maxPause
+
+	return p
+}
+
+// WithHostPolicy applies the limits of a (possibly shared) host policy to all
+// the requests of the downloader, including redirects and chunks.
+func (d *Downloader) WithHostPolicy(policy *HostPolicy) *Downloader {
+	d.hostPolicy = policy
+	return d
+}
+
+func (p *HostPolicy) state(host string) *hostState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.hosts[host]
+	if !ok {
+		s = &hostState{}
+		if p.maxConcurrent > 0 {
+			s.slots = make(chan struct{}, p.maxConcurrent)
+		}
+
+		p.hosts[host] = s
+	}
+
+	return s
+}
+
+// acquire waits until a request can be sent to the host. If it returns
+// without error, release() must be called when the request is complete.
+func (p *HostPolicy) acquire(ctx context.Context, host string) error {
+	s := p.state(host)
+
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		p.mu.Lock()
+
+		now := time.Now()
+
+		start := s.next
+		if s.pausedUntil.After(start) {
+			start = s.pausedUntil
+		}
+
+		if !start.After(now) {
+			s.next = now.Add(p.minInterval)
+			p.mu.Unlock()
+
+			return nil
+		}
+
+		p.mu.Unlock()
+
+		// the deadline can move while we wait, so check again after
+		timer := time.NewTimer(start.Sub(now))
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			p.release(host)
+
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *HostPolicy) release(host string) {
+	s := p.state(host)
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// pause prevents new requests to the host for the given duration.
+func (p *HostPolicy) pause(host string, duration time.Duration) {
+	s := p.state(host)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	until := time.Now().Add(duration)
+	if until.After(s.pausedUntil) {
+		s.pausedUntil = until
+	}
+}
+
+// retryAfter returns the pause requested by the server, or the default one.
+func (p *HostPolicy) retryAfter(resp *http.Response) time.Duration {
+	pause := p.defaultPause
+
+	value := resp.Header.Get("Retry-After")
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		pause = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		pause = time.Until(date)
+	}
+
+	if p.maxPause > 0 && pause > p.maxPause {
+		pause = p.maxPause
+	}
+
+	return pause
+}
+
+// hostPolicyTransport applies a HostPolicy to each round trip.
+type hostPolicyTransport struct {
+	policy *HostPolicy
+	next   http.RoundTripper
+	logger *logrus.Entry
+}
+
+func (t *hostPolicyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	if err := t.policy.acquire(req.Context(), host); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.policy.release(host)
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		pause := t.policy.retryAfter(resp)
+		t.logger.Warnf("%s responded with %d, pausing requests for %s", host, resp.StatusCode, pause.Round(time.Second))
+		t.policy.pause(host, pause)
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { t.policy.release(host) }}
+
+	return resp, nil
+}
+
+// releasingBody frees the slot of a request when its body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+
+	return err
+}
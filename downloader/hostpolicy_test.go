@@ -0,0 +1,162 @@
+package downloader_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestHostPolicyConcurrency(t *testing.T) {
+	ctx := context.Background()
+
+	var current, peak atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := current.Add(1)
+		defer current.Add(-1)
+
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	policy := downloader.NewHostPolicy().WithMaxConcurrent(2)
+	dir := t.TempDir()
+
+	var wg sync.WaitGroup
+
+	for i := range 6 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := downloader.New().
+				ToFile(filepath.Join(dir, fmt.Sprintf("file%d", i))).
+				WithHostPolicy(policy).
+				Download(ctx, ts.URL)
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestHostPolicyInterval(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	policy := downloader.NewHostPolicy().WithMinInterval(100 * time.Millisecond)
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	for range 3 {
+		_, err := downloader.New().
+			ToFile(dest).
+			WithHostPolicy(policy).
+			Download(ctx, ts.URL)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, times, 3)
+	assert.GreaterOrEqual(t, times[1].Sub(times[0]), 90*time.Millisecond)
+	assert.GreaterOrEqual(t, times[2].Sub(times[1]), 90*time.Millisecond)
+}
+
+func TestHostPolicyPause(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		requests   atomic.Int32
+		retryAfter atomic.Value
+	)
+
+	retryAfter.Store("")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1)%2 == 1 {
+			if v := retryAfter.Load().(string); v != "" {
+				w.Header().Set("Retry-After", v)
+			}
+
+			w.WriteHeader(http.StatusTooManyRequests)
+
+			return
+		}
+
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	policy := downloader.NewHostPolicy().WithPause(200*time.Millisecond, time.Minute)
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	d := downloader.New().
+		ToFile(dest).
+		WithHostPolicy(policy)
+
+	var codeErr downloader.BadHTTPCodeError
+
+	// without Retry-After, the default pause applies
+
+	_, err := d.Download(ctx, ts.URL)
+	require.ErrorAs(t, err, &codeErr)
+	assert.Equal(t, http.StatusTooManyRequests, codeErr.Code)
+
+	start := time.Now()
+	_, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+
+	retryAfter.Store("1")
+
+	_, err = d.Download(ctx, ts.URL)
+	require.ErrorAs(t, err, &codeErr)
+
+	// the pause is cut short by the context
+
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	_, err = d.Download(shortCtx, ts.URL)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	start = time.Now()
+	_, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 700*time.Millisecond)
+	assert.Equal(t, int32(4), requests.Load())
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+)
+
+// WithIntegrityCheck re-hashes the local file before trusting the freshness checks
+// (ETag, Last-Modified...). If it does not match, it's logged and downloaded again.
+// The expected hash is the one set with VerifyHash() or, if there is none, the
+// sha256 stored in hashPath after the previous download. hashPath can be empty
+// when VerifyHash() is used. With VerifyChecksumFile(), a local file that does
+// not match the checksum file can be outdated as well as corrupted: hashPath
+// should be set to tell them apart.
+func (d *Downloader) WithIntegrityCheck(hashPath string) *Downloader {
+	d.integrityCheck = true
+	d.hashPath = hashPath
+
+	return d
+}
+
+// hashContent returns the hex-encoded hash of a file.
+func hashContent(path string, h hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// expectedLocalHash returns the hash the local file must have, and a hasher for it.
+// With a checksum file, the value set with VerifyHash() is not used.
+func (d *Downloader) expectedLocalHash() (string, hash.Hash, error) {
+	if d.verifyHashValue != "" && d.checksumURL == "" {
+		h, err := selectHashFunction(d.verifyHashFunction)
+		return d.verifyHashValue, h, err
+	}
+
+	content, err := os.ReadFile(d.hashPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return string(bytes.TrimSpace(content)), crypto.SHA256.New(), nil
+}
+
+// isLocalIntact returns false if the integrity check is enabled and the local
+// file does not have the expected hash. checksum is the hash found in the
+// checksum file, if any: it's the hash of the remote file, so it's only used
+// when there is nothing else to compare with.
+func (d *Downloader) isLocalIntact(checksum string) bool {
+	if !d.integrityCheck {
+		return true
+	}
+
+	if checksum != "" && d.hashPath == "" {
+		if d.hasContent(checksum) {
+			return true
+		}
+
+		// corrupted or outdated, we can't tell
+		d.logger.Debugf("Local file %s does not match the checksum file, downloading again", d.destPath)
+
+		return false
+	}
+
+	expected, h, err := d.expectedLocalHash()
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		d.logger.Debugf("No hash file for %s, skipping integrity check", d.destPath)
+		return true
+	case err != nil:
+		d.logger.Errorf("Can't check integrity of %s, downloading again: %s", d.destPath, err)
+		return false
+	}
+
+	got, err := hashContent(d.destPath, h)
+	if err != nil {
+		d.logger.Errorf("Can't check integrity of %s, downloading again: %s", d.destPath, err)
+		return false
+	}
+
+	if got != expected {
+		d.logger.Warnf("Local file %s is corrupted (expected hash %s, got %s), downloading again",
+			d.destPath, expected, got)
+
+		return false
+	}
+
+	return true
+}
+
+// storeHash writes the sha256 of the downloaded file, for the next integrity check.
+func (d *Downloader) storeHash(path string) {
+	if d.hashPath == "" {
+		return
+	}
+
+	got, err := hashContent(path, crypto.SHA256.New())
+	if err != nil {
+		d.logger.Errorf("Failed to hash %s: %s", path, err)
+		return
+	}
+
+	if err := os.WriteFile(d.hashPath, []byte(got), 0o600); err != nil {
+		d.logger.Errorf("Failed to write hash to %s: %s", d.hashPath, err)
+	}
+}
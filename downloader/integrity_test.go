@@ -0,0 +1,174 @@
+package downloader_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/cstest"
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+// corrupt changes the content of a file without changing its modification time.
+func corrupt(t *testing.T, path string) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+}
+
+func TestIntegrityCheckHashFile(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	logger, hook := logtest.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+
+	d := downloader.New().
+		WithLogger(logrus.NewEntry(logger)).
+		ToFile(dest).
+		WithETagFile(dest + ".etag").
+		WithIntegrityCheck(dest + ".sha256")
+
+	downloaded, err := d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+
+	stored, err := os.ReadFile(dest + ".sha256")
+	require.NoError(t, err)
+	assert.Equal(t, sha256hex("content"), string(stored))
+
+	downloaded, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+
+	// the ETag still matches, but the file has been modified
+
+	corrupt(t, dest)
+
+	downloaded, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+	cstest.RequireLogContains(t, hook, "is corrupted (expected hash "+sha256hex("content")+", got "+sha256hex("garbage")+")")
+
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestIntegrityCheckVerifyHash(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Now().Add(-time.Hour), strings.NewReader("content"))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	d := downloader.New().
+		ToFile(dest).
+		WithLastModified().
+		VerifyHash("sha256", sha256hex("content")).
+		WithIntegrityCheck("")
+
+	downloaded, err := d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+
+	downloaded, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+
+	corrupt(t, dest)
+
+	downloaded, err = d.Download(ctx, ts.URL)
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+
+	_, err = downloader.New().
+		ToFile(dest).
+		WithIntegrityCheck("").
+		Download(ctx, ts.URL)
+	require.ErrorContains(t, err, "integrity check requires a hash value or a hash file")
+}
+
+func TestIntegrityCheckChecksumFile(t *testing.T) {
+	ctx := context.Background()
+
+	var content atomic.Value
+
+	content.Store("v1")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := content.Load().(string)
+
+		if r.URL.Path == "/file.sha256" {
+			_, _ = io.WriteString(w, sha256hex(current)+"\n")
+			return
+		}
+
+		w.Header().Set("ETag", `"`+current+`"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(current))
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "file")
+
+	logger, hook := logtest.NewNullLogger()
+
+	d := downloader.New().
+		WithLogger(logrus.NewEntry(logger)).
+		ToFile(dest).
+		WithETagFile(dest + ".etag").
+		VerifyChecksumFile("file.sha256").
+		WithIntegrityCheck(dest + ".sha256")
+
+	downloaded, err := d.Download(ctx, ts.URL+"/file")
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+
+	downloaded, err = d.Download(ctx, ts.URL+"/file")
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+
+	// an update of the remote file is not a corruption of the local one
+
+	content.Store("v2")
+
+	downloaded, err = d.Download(ctx, ts.URL+"/file")
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+
+	for _, entry := range hook.AllEntries() {
+		assert.NotContains(t, entry.Message, "corrupted")
+	}
+
+	corrupt(t, dest)
+
+	downloaded, err = d.Download(ctx, ts.URL+"/file")
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+	cstest.RequireLogContains(t, hook, "is corrupted")
+}
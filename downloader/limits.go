@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+)
+
+// the compression ratio is not checked before this many bytes are decoded,
+// small payloads can have any ratio without harm
+const ratioCheckMinSize = 1 << 20
+
+// WireSizeError is returned when the content sent by the server, possibly
+// compressed, is larger than the limit.
+type WireSizeError struct {
+	Limit int64
+	// ContentLength is set if the size was announced by the server.
+	ContentLength int64
+}
+
+func (e WireSizeError) Error() string {
+	if e.ContentLength > 0 {
+		return fmt.Sprintf("refusing to download file larger than %d bytes: Content-Length=%d", e.Limit, e.ContentLength)
+	}
+
+	return fmt.Sprintf("more than %d bytes received", e.Limit)
+}
+
+func (WireSizeError) Is(target error) bool {
+	return target == ErrSizeLimitExceeded
+}
+
+// DecodedSizeError is returned when the content, after decompression,
+// is larger than the limit.
+type DecodedSizeError struct {
+	Limit int64
+}
+
+func (e DecodedSizeError) Error() string {
+	return fmt.Sprintf("limit of %d bytes exceeded", e.Limit)
+}
+
+func (DecodedSizeError) Is(target error) bool {
+	return target == ErrSizeLimitExceeded
+}
+
+// CompressionRatioError is returned when the decompressed content grows too
+// fast compared to the received bytes, as in a decompression bomb.
+type CompressionRatioError struct {
+	Limit   float64
+	Wire    int64
+	Decoded int64
+}
+
+func (e CompressionRatioError) Error() string {
+	return fmt.Sprintf("compression ratio over %g (%d bytes decoded from %d received)", e.Limit, e.Decoded, e.Wire)
+}
+
+// LimitWireSize sets the maximum number of bytes to receive, before decompression.
+// If not set, the limit of LimitDownloadSize() applies.
+func (d *Downloader) LimitWireSize(size int64) *Downloader {
+	d.maxWireSize = size
+	return d
+}
+
+// LimitDecodedSize sets the maximum size of the file after decompression.
+// If not set, the limit of LimitDownloadSize() applies.
+func (d *Downloader) LimitDecodedSize(size int64) *Downloader {
+	d.maxDecodedSize = size
+	return d
+}
+
+// LimitCompressionRatio sets the maximum ratio between decompressed and received
+// bytes. It's checked during the download, once the first megabyte has been decoded.
+func (d *Downloader) LimitCompressionRatio(ratio float64) *Downloader {
+	d.maxRatio = ratio
+	return d
+}
+
+func (d *Downloader) wireLimit() int64 {
+	if d.maxWireSize > 0 {
+		return d.maxWireSize
+	}
+
+	return d.maxSize
+}
+
+func (d *Downloader) decodedLimit() int64 {
+	if d.maxDecodedSize > 0 {
+		return d.maxDecodedSize
+	}
+
+	return d.maxSize
+}
+
+// wireReader counts the bytes received and stops after the limit, if there is one.
+type wireReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (w *wireReader) Read(p []byte) (int, error) {
+	if w.limit > 0 && int64(len(p)) > w.limit-w.n+1 {
+		// read one more byte than allowed to tell if the limit is exceeded
+		p = p[:w.limit-w.n+1]
+	}
+
+	n, err := w.r.Read(p)
+	w.n += int64(n)
+
+	if w.limit > 0 && w.n > w.limit {
+		return n, WireSizeError{Limit: w.limit}
+	}
+
+	return n, err
+}
+
+// decodedReader counts the decompressed bytes, checks their size and the
+// compression ratio.
+type decodedReader struct {
+	r        io.Reader
+	wire     *wireReader
+	limit    int64
+	maxRatio float64
+	n        int64
+}
+
+func (d *decodedReader) Read(p []byte) (int, error) {
+	if d.limit > 0 && int64(len(p)) > d.limit-d.n+1 {
+		p = p[:d.limit-d.n+1]
+	}
+
+	n, err := d.r.Read(p)
+	d.n += int64(n)
+
+	if d.limit > 0 && d.n > d.limit {
+		return n, DecodedSizeError{Limit: d.limit}
+	}
+
+	if d.maxRatio > 0 && d.n >= ratioCheckMinSize && float64(d.n) > d.maxRatio*float64(d.wire.n) {
+		return n, CompressionRatioError{Limit: d.maxRatio, Wire: d.wire.n, Decoded: d.n}
+	}
+
+	return n, err
+}
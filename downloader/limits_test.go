@@ -0,0 +1,128 @@
+package downloader_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestDecompressionLimits(t *testing.T) {
+	ctx := context.Background()
+
+	// 10MB of zeros compress to a few KB
+	plain := make([]byte, 10<<20)
+
+	var bomb bytes.Buffer
+
+	gz, err := gzip.NewWriterLevel(&bomb, gzip.BestCompression)
+	require.NoError(t, err)
+	_, _ = gz.Write(plain)
+	require.NoError(t, gz.Close())
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bomb":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", strconv.Itoa(bomb.Len()))
+			_, _ = w.Write(bomb.Bytes())
+		case "/streamed":
+			// no Content-Length
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(bomb.Bytes()[:100])
+			w.(http.Flusher).Flush()
+			_, _ = w.Write(bomb.Bytes()[100:])
+		case "/plain":
+			_, _ = w.Write(plain[:1000])
+		}
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.bin")
+
+	t.Run("compression ratio", func(t *testing.T) {
+		_, err := downloader.New().
+			ToFile(dest).
+			LimitCompressionRatio(100).
+			Download(ctx, ts.URL+"/bomb")
+
+		var ratioErr downloader.CompressionRatioError
+
+		require.ErrorAs(t, err, &ratioErr)
+		assert.InDelta(t, 100, ratioErr.Limit, 0)
+		assert.Greater(t, ratioErr.Decoded, 100*ratioErr.Wire)
+		assert.Less(t, ratioErr.Decoded, int64(len(plain)))
+		assert.NoFileExists(t, dest)
+	})
+
+	t.Run("decoded size", func(t *testing.T) {
+		_, err := downloader.New().
+			ToFile(dest).
+			LimitDecodedSize(1<<20).
+			Download(ctx, ts.URL+"/bomb")
+
+		var sizeErr downloader.DecodedSizeError
+
+		require.ErrorAs(t, err, &sizeErr)
+		require.ErrorIs(t, err, downloader.ErrSizeLimitExceeded)
+		require.ErrorContains(t, err, "halted: limit of 1048576 bytes exceeded")
+	})
+
+	t.Run("wire size from Content-Length", func(t *testing.T) {
+		_, err := downloader.New().
+			ToFile(dest).
+			LimitWireSize(1000).
+			Download(ctx, ts.URL+"/bomb")
+
+		var sizeErr downloader.WireSizeError
+
+		require.ErrorAs(t, err, &sizeErr)
+		assert.Equal(t, downloader.WireSizeError{Limit: 1000, ContentLength: int64(bomb.Len())}, sizeErr)
+	})
+
+	t.Run("wire size while streaming", func(t *testing.T) {
+		_, err := downloader.New().
+			ToFile(dest).
+			LimitWireSize(1000).
+			Download(ctx, ts.URL+"/streamed")
+
+		var sizeErr downloader.WireSizeError
+
+		require.ErrorAs(t, err, &sizeErr)
+		require.ErrorContains(t, err, "halted: more than 1000 bytes received")
+	})
+
+	t.Run("separate limits are respected", func(t *testing.T) {
+		downloaded, err := downloader.New().
+			ToFile(dest).
+			LimitWireSize(int64(bomb.Len())).
+			LimitDecodedSize(int64(len(plain))).
+			Download(ctx, ts.URL+"/bomb")
+		require.NoError(t, err)
+		assert.True(t, downloaded)
+	})
+
+	t.Run("exact size", func(t *testing.T) {
+		downloaded, err := downloader.New().
+			ToFile(dest).
+			LimitDownloadSize(1000).
+			Download(ctx, ts.URL+"/plain")
+		require.NoError(t, err)
+		assert.True(t, downloaded)
+
+		_, err = downloader.New().
+			ToFile(dest).
+			LimitDecodedSize(999).
+			Download(ctx, ts.URL+"/plain")
+		require.ErrorIs(t, err, downloader.ErrSizeLimitExceeded)
+	})
+}
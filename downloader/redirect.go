@@ -0,0 +1,203 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// same as the default policy of net/http
+const defaultMaxRedirects = 10
+
+// RedirectError is returned when a redirect is refused by the policy.
+type RedirectError struct {
+	From   string
+	To     string
+	Reason string
+}
+
+func (e RedirectError) Error() string {
+	return fmt.Sprintf("redirect from %s to %s refused: %s", e.From, e.To, e.Reason)
+}
+
+// WithMaxRedirects sets the maximum number of redirects to follow. Zero forbids
+// redirects. If not set, the policy of the http client applies.
+func (d *Downloader) WithMaxRedirects(n int) *Downloader {
+	d.maxRedirects = n
+	return d
+}
+
+// ForbidInsecureRedirects refuses redirects from HTTPS to HTTP.
+func (d *Downloader) ForbidInsecureRedirects() *Downloader {
+	d.forbidDowngrade = true
+	return d
+}
+
+// WithRedirectHosts only allows redirects to the given hosts. A host can start
+// with "*." to allow all its subdomains. The host of the original URL is not checked.
+func (d *Downloader) WithRedirectHosts(hosts ...string) *Downloader {
+	d.redirectHosts = hosts
+	return d
+}
+
+func (d *Downloader) isRedirectHostAllowed(host string) bool {
+	host = strings.ToLower(host)
+
+	return slices.ContainsFunc(d.redirectHosts, func(allowed string) bool {
+		allowed = strings.ToLower(allowed)
+
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			return strings.HasSuffix(host, suffix)
+		}
+
+		return host == allowed
+	})
+}
+
+// checkRedirect applies the redirect policy. The http client will call it
+// before following each redirect.
+func (d *Downloader) checkRedirect(req *http.Request, via []*http.Request) error {
+	prev := via[len(via)-1]
+
+	refuse := func(reason string) error {
+		return RedirectError{From: prev.URL.Redacted(), To: req.URL.Redacted(), Reason: reason}
+	}
+
+	if d.maxRedirects >= 0 && len(via) > d.maxRedirects {
+		return refuse(fmt.Sprintf("more than %d redirects", d.maxRedirects))
+	}
+
+	if d.forbidDowngrade && prev.URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return refuse("downgrade from HTTPS")
+	}
+
+	if len(d.redirectHosts) > 0 && !d.isRedirectHostAllowed(req.URL.Hostname()) {
+		return refuse("host not allowed")
+	}
+
+	d.logger.Debugf("Redirect %d: %s -> %s", len(via), prev.URL.Redacted(), req.URL.Redacted())
+
+	return nil
+}
+
+// boundETag is an ETag that is only sent to the URL it was received from, so that
+// the validator of a mirror is not sent to another one. If url is empty, the ETag
+// is sent to any URL.
+type boundETag struct {
+	value string
+	url   string
+}
+
+// apply sets or removes the If-None-Match header according to the URL of the request.
+func (b *boundETag) apply(req *http.Request) {
+	if b == nil || b.value == "" {
+		return
+	}
+
+	if b.url != "" && b.url != req.URL.String() {
+		req.Header.Del("If-None-Match")
+		return
+	}
+
+	req.Header.Set("If-None-Match", b.value)
+}
+
+// bindETag returns the ETag to send for a request to url. When read from an ETag
+// file, it's bound to the final URL of the request that returned it.
+func (d *Downloader) bindETag(url, etag string) *boundETag {
+	if etag == "" {
+		return nil
+	}
+
+	bound := &boundETag{value: etag}
+
+	if d.etagPath != "" {
+		bound.url = url
+
+		if _, etagURL, err := readETagFile(d.etagPath); err == nil && etagURL != "" {
+			bound.url = etagURL
+		}
+	}
+
+	return bound
+}
+
+// do sends a request with the redirect policy and the ETag, if any.
+func (d *Downloader) do(req *http.Request, etag *boundETag) (*http.Response, error) {
+	client := http.DefaultClient
+	if d.httpClient != nil {
+		client = d.httpClient
+	}
+
+	// don't modify the client we were given
+	c := *client
+
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := d.checkRedirect(req, via); err != nil {
+			return err
+		}
+
+		etag.apply(req)
+
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+
+		if d.maxRedirects < 0 && len(via) >= defaultMaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)
+		}
+
+		return nil
+	}
+
+	if d.hostPolicy != nil {
+		transport := c.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+
+		c.Transport = &hostPolicyTransport{policy: d.hostPolicy, next: transport, logger: d.logger}
+	}
+
+	etag.apply(req)
+
+	return c.Do(req)
+}
+
+// redirectChain returns the URLs from the original request to the final one,
+// or nil if there was no redirect.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+
+	for req := resp.Request; req != nil; {
+		chain = append(chain, req.URL.Redacted())
+
+		if req.Response == nil {
+			break
+		}
+
+		req = req.Response.Request
+	}
+
+	if len(chain) < 2 {
+		return nil
+	}
+
+	slices.Reverse(chain)
+
+	return chain
+}
+
+// readETagFile returns the ETag and the URL it is bound to, if any.
+func readETagFile(etagPath string) (string, string, error) {
+	content, err := os.ReadFile(etagPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	etag, url, _ := strings.Cut(string(content), "\n")
+
+	return etag, strings.TrimSpace(url), nil
+}
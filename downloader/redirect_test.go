@@ -0,0 +1,177 @@
+package downloader_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/mid", http.StatusFound)
+		case "/mid":
+			http.Redirect(w, r, "/file", http.StatusMovedPermanently)
+		case "/file":
+			_, _ = io.WriteString(w, "content")
+		}
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	result, err := downloader.New().
+		ToFile(dest).
+		DownloadWithResult(ctx, ts.URL+"/start")
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeDownloaded, result.Outcome)
+	assert.Equal(t, []string{ts.URL + "/start", ts.URL + "/mid", ts.URL + "/file"}, result.Redirects)
+
+	result, err = downloader.New().
+		ToFile(dest).
+		DownloadWithResult(ctx, ts.URL+"/file")
+	require.NoError(t, err)
+	assert.Nil(t, result.Redirects)
+
+	var redirectErr downloader.RedirectError
+
+	_, err = downloader.New().
+		ToFile(dest).
+		WithMaxRedirects(1).
+		Download(ctx, ts.URL+"/start")
+	require.ErrorAs(t, err, &redirectErr)
+	assert.Equal(t, downloader.RedirectError{
+		From:   ts.URL + "/mid",
+		To:     ts.URL + "/file",
+		Reason: "more than 1 redirects",
+	}, redirectErr)
+
+	_, err = downloader.New().
+		ToFile(dest).
+		WithRedirectHosts("example.com", "*.example.com").
+		Download(ctx, ts.URL+"/start")
+	require.ErrorAs(t, err, &redirectErr)
+	assert.Equal(t, "host not allowed", redirectErr.Reason)
+
+	_, err = downloader.New().
+		ToFile(dest).
+		WithRedirectHosts("127.0.0.1").
+		Download(ctx, ts.URL+"/start")
+	require.NoError(t, err)
+}
+
+func TestRedirectDowngrade(t *testing.T) {
+	ctx := context.Background()
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer plain.Close()
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL, http.StatusFound)
+	}))
+	defer secure.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	_, err := downloader.New().
+		ToFile(dest).
+		WithHTTPClient(secure.Client()).
+		Download(ctx, secure.URL)
+	require.NoError(t, err)
+
+	var redirectErr downloader.RedirectError
+
+	_, err = downloader.New().
+		ToFile(dest).
+		WithHTTPClient(secure.Client()).
+		ForbidInsecureRedirects().
+		Download(ctx, secure.URL)
+	require.ErrorAs(t, err, &redirectErr)
+	assert.Equal(t, "downgrade from HTTPS", redirectErr.Reason)
+
+	// the client we passed has not been modified
+	assert.Nil(t, secure.Client().CheckRedirect)
+}
+
+func TestRedirectETagBoundToMirror(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		mu     sync.Mutex
+		mirror = "/mirror1"
+		sent   = map[string]string{}
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, mirror, http.StatusFound)
+			return
+		}
+
+		sent[r.Method+" "+r.URL.Path] = r.Header.Get("If-None-Match")
+
+		// both mirrors use the same kind of ETag for different content
+		w.Header().Set("ETag", `"1"`)
+
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		_, _ = io.WriteString(w, "content from "+r.URL.Path)
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	d := downloader.New().
+		ToFile(dest).
+		WithETagFile(dest + ".etag")
+
+	downloaded, err := d.Download(ctx, ts.URL+"/start")
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+
+	stored, err := os.ReadFile(dest + ".etag")
+	require.NoError(t, err)
+	assert.Equal(t, "\"1\"\n"+ts.URL+"/mirror1", string(stored))
+
+	downloaded, err = d.Download(ctx, ts.URL+"/start")
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+	assert.Equal(t, `"1"`, sent["HEAD /mirror1"])
+
+	// the redirect now goes to another mirror, which must not receive the ETag of the first one
+
+	mu.Lock()
+	mirror = "/mirror2"
+	mu.Unlock()
+
+	downloaded, err = d.Download(ctx, ts.URL+"/start")
+	require.NoError(t, err)
+	assert.True(t, downloaded)
+	assert.Empty(t, sent["HEAD /mirror2"])
+	assert.Empty(t, sent["GET /mirror2"])
+
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "content from /mirror2", string(content))
+}
@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Outcome tells what DownloadWithResult() did with the local file.
+type Outcome int
+
+const (
+	// OutcomeUpToDate means the local file was already current.
+	OutcomeUpToDate Outcome = iota
+	// OutcomeDownloaded means the local file has been replaced.
+	OutcomeDownloaded
+	// OutcomeStale means the download failed, and the existing local file can be used instead.
+	OutcomeStale
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeUpToDate:
+		return "up to date"
+	case OutcomeDownloaded:
+		return "downloaded"
+	case OutcomeStale:
+		return "stale"
+	default:
+		return fmt.Sprintf("Outcome(%d)", int(o))
+	}
+}
+
+// Result is returned by DownloadWithResult().
+type Result struct {
+	// Warning is set when Outcome is OutcomeStale, and wraps the download error in a StaleError.
+	Warning error
+	// Redirects lists the URLs from the requested one to the final one,
+	// if the server responded with a redirect.
+	Redirects []string
+	Outcome   Outcome
+	// FromCache is true if the file was installed from the cache set with WithCache().
+	FromCache bool
+	// set when the downloaded file is part of a transaction
+	staged *stagedFile
+}
+
+// StaleError is the warning reported when a stale local file is used because
+// the download failed.
+type StaleError struct {
+	Err error
+	Age time.Duration
+}
+
+func (e StaleError) Error() string {
+	return fmt.Sprintf("using stale local file (age %s): %s", e.Age.Round(time.Second), e.Err)
+}
+
+func (e StaleError) Unwrap() error {
+	return e.Err
+}
+
+// WithStaleOnError makes the download succeed with OutcomeStale when it fails
+// but the destination file exists and was modified less than maxStale ago.
+// This allows a service to start with the last known good data when the network is down.
+func (d *Downloader) WithStaleOnError(maxStale time.Duration) *Downloader {
+	d.maxStale = maxStale
+	return d
+}
+
+// staleFallback returns a StaleError if the local file can be used after a failed download.
+// A canceled download is not a failure: the caller does not want the file anymore.
+func (d *Downloader) staleFallback(downloadErr error) error {
+	if d.maxStale == 0 || errors.Is(downloadErr, context.Canceled) {
+		return nil
+	}
+
+	modTime, _ := d.getDestInfo()
+	if modTime.IsZero() {
+		return nil
+	}
+
+	age := time.Since(modTime)
+	if age > d.maxStale {
+		d.logger.Debugf("Local file %s is too old to be used (age %s)", d.destPath, age.Round(time.Second))
+		return nil
+	}
+
+	return StaleError{Err: downloadErr, Age: age}
+}
+
+// DownloadWithResult is like Download() but returns the details of the outcome.
+func (d *Downloader) DownloadWithResult(ctx context.Context, url string) (*Result, error) {
+	// only one of etagfn, ifmod, lastmod
+	if err := d.ValidateOptions(); err != nil {
+		return nil, fmt.Errorf("downloader options: %w", err)
+	}
+
+	result := &Result{}
+
+	downloaded, err := d.guardedDownload(ctx, url, result)
+	if err != nil {
+		stale := d.staleFallback(err)
+		if stale == nil {
+			return nil, err
+		}
+
+		d.logger.Warn(stale)
+
+		result.Outcome = OutcomeStale
+		result.Warning = stale
+
+		return result, nil
+	}
+
+	result.Outcome = OutcomeUpToDate
+	if downloaded {
+		result.Outcome = OutcomeDownloaded
+	}
+
+	return result, nil
+}
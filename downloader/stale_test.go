@@ -0,0 +1,83 @@
+package downloader_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestStaleOnError(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "content")
+	}))
+
+	dest := filepath.Join(t.TempDir(), "example.txt")
+
+	d := downloader.New().
+		ToFile(dest).
+		WithStaleOnError(24 * time.Hour)
+
+	url := ts.URL
+
+	result, err := d.DownloadWithResult(ctx, url)
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeDownloaded, result.Outcome)
+	require.NoError(t, result.Warning)
+
+	// the network is down
+
+	ts.Close()
+
+	result, err = d.DownloadWithResult(ctx, url)
+	require.NoError(t, err)
+	assert.Equal(t, downloader.OutcomeStale, result.Outcome)
+
+	var stale downloader.StaleError
+
+	require.ErrorAs(t, result.Warning, &stale)
+	require.ErrorContains(t, stale.Err, "failed http request for "+url)
+
+	downloaded, err := d.Download(ctx, url)
+	require.NoError(t, err)
+	assert.False(t, downloaded)
+
+	// canceled, not failed
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = d.DownloadWithResult(canceledCtx, url)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// too old
+
+	old := time.Now().Add(-25 * time.Hour)
+	require.NoError(t, os.Chtimes(dest, old, old))
+
+	_, err = d.DownloadWithResult(ctx, url)
+	require.ErrorContains(t, err, "failed http request for "+url)
+
+	// no local file
+
+	require.NoError(t, os.Remove(dest))
+
+	_, err = d.DownloadWithResult(ctx, url)
+	require.ErrorContains(t, err, "failed http request for "+url)
+
+	// disabled
+
+	_, err = downloader.New().ToFile(dest).Download(ctx, url)
+	require.ErrorContains(t, err, "failed http request for "+url)
+}
@@ -0,0 +1,328 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ManifestEntry describes a file that must be present in the target directory.
+type ManifestEntry struct {
+	// Path is relative to the target directory, with forward slashes.
+	Path   string   `json:"path"           yaml:"path"`
+	URL    string   `json:"url"            yaml:"url"`
+	SHA256 string   `json:"sha256"         yaml:"sha256"`
+	Mode   FileMode `json:"mode,omitempty" yaml:"mode,omitempty"`
+}
+
+// SyncAction is what Sync did (or would do, in dry-run mode) to a file.
+type SyncAction string
+
+const (
+	SyncAdd        SyncAction = "add"
+	SyncUpdate     SyncAction = "update"
+	SyncRemove     SyncAction = "remove"
+	SyncQuarantine SyncAction = "quarantine"
+	SyncUnchanged  SyncAction = "unchanged"
+)
+
+// SyncChange reports the action taken on a single file.
+// Err is set if the action was attempted and failed.
+type SyncChange struct {
+	Path   string
+	Action SyncAction
+	Err    error
+}
+
+// SyncReport lists the changes applied by Sync, or planned in dry-run mode.
+// Files in the manifest come first, in manifest order, followed by the
+// removed files in lexical order.
+type SyncReport struct {
+	Changes []SyncChange
+	DryRun  bool
+}
+
+// Changed returns true if at least one file was (or would be) added, updated or removed.
+func (r *SyncReport) Changed() bool {
+	for _, c := range r.Changes {
+		if c.Action != SyncUnchanged && c.Err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Syncer keeps a directory in sync with a manifest of remote files.
+type Syncer struct {
+	logger        *logrus.Entry
+	httpClient    *http.Client
+	targetDir     string
+	quarantineDir string
+	maxSize       int64
+	dryRun        bool
+}
+
+// NewSyncer creates a syncer for the given target directory.
+func NewSyncer(targetDir string) *Syncer {
+	return &Syncer{
+		logger:     nullLogger(),
+		httpClient: http.DefaultClient,
+		targetDir:  targetDir,
+	}
+}
+
+// WithLogger sets the logger for the syncer and its downloads.
+func (s *Syncer) WithLogger(logger *logrus.Entry) *Syncer {
+	s.logger = logger
+	return s
+}
+
+// WithHTTPClient sets the http client used for all downloads.
+func (s *Syncer) WithHTTPClient(client *http.Client) *Syncer {
+	s.httpClient = client
+	return s
+}
+
+// WithQuarantine moves the files that are no longer in the manifest to the given
+// directory, keeping their relative path, instead of deleting them.
+func (s *Syncer) WithQuarantine(dir string) *Syncer {
+	s.quarantineDir = dir
+	return s
+}
+
+// LimitDownloadSize sets the maximum size of each downloaded file.
+func (s *Syncer) LimitDownloadSize(size int64) *Syncer {
+	s.maxSize = size
+	return s
+}
+
+// DryRun computes the changes without downloading or removing anything.
+func (s *Syncer) DryRun(dryRun bool) *Syncer {
+	s.dryRun = dryRun
+	return s
+}
+
+// localPath validates a manifest path and returns it as a path inside the target directory.
+func (s *Syncer) localPath(relPath string) (string, error) {
+	if relPath == "" {
+		return "", errors.New("empty path")
+	}
+
+	clean := filepath.FromSlash(relPath)
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("path %q is outside of the target directory", relPath)
+	}
+
+	return filepath.Join(s.targetDir, clean), nil
+}
+
+// validateManifest checks all the entries before doing anything, so that a bad
+// manifest does not leave the directory half-synced.
+func (s *Syncer) validateManifest(manifest []ManifestEntry) (map[string]struct{}, error) {
+	var errs []error
+
+	known := make(map[string]struct{}, len(manifest))
+
+	for i, entry := range manifest {
+		local, err := s.localPath(entry.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("manifest entry %d: %w", i, err))
+			continue
+		}
+
+		if _, ok := known[local]; ok {
+			errs = append(errs, fmt.Errorf("manifest entry %d: duplicate path %q", i, entry.Path))
+		}
+
+		known[local] = struct{}{}
+
+		if entry.URL == "" {
+			errs = append(errs, fmt.Errorf("manifest entry %d (%s): url must be set", i, entry.Path))
+		}
+
+		if entry.SHA256 == "" {
+			errs = append(errs, fmt.Errorf("manifest entry %d (%s): sha256 must be set", i, entry.Path))
+		} else if _, _, err := parseHash("sha256:" + entry.SHA256); err != nil {
+			errs = append(errs, fmt.Errorf("manifest entry %d (%s): %w", i, entry.Path, err))
+		}
+	}
+
+	return known, errors.Join(errs...)
+}
+
+// syncEntry downloads a single entry if the local copy is missing or has a different hash.
+func (s *Syncer) syncEntry(ctx context.Context, entry ManifestEntry) SyncChange {
+	change := SyncChange{Path: entry.Path}
+
+	local, _ := s.localPath(entry.Path)
+
+	localHash, err := SHA256(local)
+	if err != nil {
+		change.Err = err
+		return change
+	}
+
+	switch {
+	case localHash == "":
+		change.Action = SyncAdd
+	case !strings.EqualFold(localHash, entry.SHA256):
+		change.Action = SyncUpdate
+	default:
+		change.Action = SyncUnchanged
+		return change
+	}
+
+	if s.dryRun {
+		return change
+	}
+
+	d := New().
+		WithLogger(s.logger.WithField("file", entry.Path)).
+		WithHTTPClient(s.httpClient).
+		ToFile(local).
+		WithMakeDirs(true).
+		WithMode(os.FileMode(entry.Mode)).
+		LimitDownloadSize(s.maxSize).
+		VerifyHash("sha256", strings.ToLower(entry.SHA256))
+
+	if _, err := d.Download(ctx, entry.URL); err != nil {
+		change.Err = err
+	}
+
+	return change
+}
+
+// extraFiles returns the files in the target directory that are not in the manifest.
+func (s *Syncer) extraFiles(known map[string]struct{}) ([]string, error) {
+	var extra []string
+
+	absQuarantine := ""
+	if s.quarantineDir != "" {
+		absQuarantine, _ = filepath.Abs(s.quarantineDir)
+	}
+
+	err := filepath.WalkDir(s.targetDir, func(path string, entry fs.DirEntry, err error) error {
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && path == s.targetDir:
+			// nothing has been synced yet
+			return filepath.SkipAll
+		case err != nil:
+			return err
+		}
+
+		if entry.IsDir() {
+			if absQuarantine != "" {
+				if abs, _ := filepath.Abs(path); abs == absQuarantine {
+					return filepath.SkipDir
+				}
+			}
+
+			return nil
+		}
+
+		if _, ok := known[path]; !ok {
+			extra = append(extra, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while listing %s: %w", s.targetDir, err)
+	}
+
+	slices.Sort(extra)
+
+	return extra, nil
+}
+
+// removeFile deletes or quarantines a file that is no longer in the manifest.
+func (s *Syncer) removeFile(path string) SyncChange {
+	rel, _ := filepath.Rel(s.targetDir, path)
+
+	change := SyncChange{Path: filepath.ToSlash(rel), Action: SyncRemove}
+
+	if s.quarantineDir != "" {
+		change.Action = SyncQuarantine
+	}
+
+	if s.dryRun {
+		return change
+	}
+
+	if s.quarantineDir == "" {
+		change.Err = os.Remove(path)
+		return change
+	}
+
+	dest := filepath.Join(s.quarantineDir, rel)
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		change.Err = err
+		return change
+	}
+
+	change.Err = os.Rename(path, dest)
+
+	return change
+}
+
+// Sync makes the target directory match the manifest: missing or changed files
+// (according to their sha256) are downloaded, and files that are not listed are
+// removed or quarantined. Errors on single files don't stop the process, they are
+// collected in the report and returned together.
+func (s *Syncer) Sync(ctx context.Context, manifest []ManifestEntry) (*SyncReport, error) {
+	known, err := s.validateManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SyncReport{DryRun: s.dryRun}
+
+	extra, err := s.extraFiles(known)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+
+	for _, entry := range manifest {
+		change := s.syncEntry(ctx, entry)
+		report.Changes = append(report.Changes, change)
+
+		if change.Err != nil {
+			s.logger.Errorf("Failed to %s %s: %s", change.Action, change.Path, change.Err)
+			errs = append(errs, fmt.Errorf("%s: %w", change.Path, change.Err))
+
+			continue
+		}
+
+		if change.Action != SyncUnchanged {
+			s.logger.Debugf("%s %s", change.Action, change.Path)
+		}
+	}
+
+	for _, path := range extra {
+		change := s.removeFile(path)
+		report.Changes = append(report.Changes, change)
+
+		if change.Err != nil {
+			s.logger.Errorf("Failed to %s %s: %s", change.Action, change.Path, change.Err)
+			errs = append(errs, fmt.Errorf("%s: %w", change.Path, change.Err))
+
+			continue
+		}
+
+		s.logger.Debugf("%s %s", change.Action, change.Path)
+	}
+
+	return report, errors.Join(errs...)
+}
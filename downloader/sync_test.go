@@ -0,0 +1,182 @@
+package downloader_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func sha256hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func actions(report *downloader.SyncReport) map[string]downloader.SyncAction {
+	ret := make(map[string]downloader.SyncAction)
+	for _, c := range report.Changes {
+		ret[c.Path] = c.Action
+	}
+
+	return ret
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+
+	files := map[string]string{
+		"/a": "content-a",
+		"/b": "content-b",
+	}
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		content, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = io.WriteString(w, content)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+
+	manifest := []downloader.ManifestEntry{
+		{Path: "a.txt", URL: ts.URL + "/a", SHA256: sha256hex("content-a")},
+		{Path: "sub/b.txt", URL: ts.URL + "/b", SHA256: sha256hex("content-b"), Mode: 0o600},
+	}
+
+	// dry run: nothing is downloaded
+
+	report, err := downloader.NewSyncer(dir).DryRun(true).Sync(ctx, manifest)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.True(t, report.Changed())
+	assert.Equal(t, map[string]downloader.SyncAction{
+		"a.txt":     downloader.SyncAdd,
+		"sub/b.txt": downloader.SyncAdd,
+	}, actions(report))
+	assert.Equal(t, int32(0), requests.Load())
+	assert.NoFileExists(t, filepath.Join(dir, "a.txt"))
+
+	// first sync
+
+	s := downloader.NewSyncer(dir)
+
+	report, err = s.Sync(ctx, manifest)
+	require.NoError(t, err)
+	assert.True(t, report.Changed())
+	assert.Equal(t, int32(2), requests.Load())
+
+	content, err := os.ReadFile(filepath.Join(dir, "sub", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "content-b", string(content))
+
+	// nothing changed, no request
+
+	report, err = s.Sync(ctx, manifest)
+	require.NoError(t, err)
+	assert.False(t, report.Changed())
+	assert.Equal(t, int32(2), requests.Load())
+
+	// a local file is modified and must be restored, an extra file is removed
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("edited"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "extra.txt"), []byte("extra"), 0o644))
+
+	report, err = s.Sync(ctx, manifest)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]downloader.SyncAction{
+		"a.txt":     downloader.SyncUpdate,
+		"sub/b.txt": downloader.SyncUnchanged,
+		"extra.txt": downloader.SyncRemove,
+	}, actions(report))
+	assert.NoFileExists(t, filepath.Join(dir, "extra.txt"))
+
+	// entry removed from the manifest goes to quarantine
+
+	quarantine := filepath.Join(dir, ".quarantine")
+
+	report, err = s.WithQuarantine(quarantine).Sync(ctx, manifest[:1])
+	require.NoError(t, err)
+	assert.Equal(t, map[string]downloader.SyncAction{
+		"a.txt":     downloader.SyncUnchanged,
+		"sub/b.txt": downloader.SyncQuarantine,
+	}, actions(report))
+	assert.NoFileExists(t, filepath.Join(dir, "sub", "b.txt"))
+	assert.FileExists(t, filepath.Join(quarantine, "sub", "b.txt"))
+
+	// the quarantine directory is not synced itself
+
+	report, err = s.Sync(ctx, manifest[:1])
+	require.NoError(t, err)
+	assert.False(t, report.Changed())
+}
+
+func TestSyncErrors(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+
+	_, err := downloader.NewSyncer(dir).Sync(ctx, []downloader.ManifestEntry{
+		{Path: "../escape", URL: ts.URL, SHA256: sha256hex("content")},
+		{Path: "nohash", URL: ts.URL},
+		{Path: "badhash", URL: ts.URL, SHA256: "abc"},
+	})
+	require.ErrorContains(t, err, `manifest entry 0: path "../escape" is outside of the target directory`)
+	require.ErrorContains(t, err, "manifest entry 1 (nohash): sha256 must be set")
+	require.ErrorContains(t, err, "manifest entry 2 (badhash): sha256 value must be 64 hex characters")
+
+	// a hash mismatch does not prevent the other files from being synced
+
+	report, err := downloader.NewSyncer(dir).Sync(ctx, []downloader.ManifestEntry{
+		{Path: "bad", URL: ts.URL, SHA256: sha256hex("other")},
+		{Path: "good", URL: ts.URL, SHA256: sha256hex("content")},
+	})
+
+	var mismatch downloader.HashMismatchError
+
+	require.ErrorAs(t, err, &mismatch)
+	require.Len(t, report.Changes, 2)
+	require.Error(t, report.Changes[0].Err)
+	require.NoError(t, report.Changes[1].Err)
+	assert.NoFileExists(t, filepath.Join(dir, "bad"))
+	assert.FileExists(t, filepath.Join(dir, "good"))
+}
+
+func TestManifestEntryMode(t *testing.T) {
+	var entries []downloader.ManifestEntry
+
+	require.NoError(t, yaml.Unmarshal([]byte("- path: a\n  mode: 0640\n- path: b\n  mode: \"0600\"\n"), &entries))
+	require.Len(t, entries, 2)
+	assert.Equal(t, downloader.FileMode(0o640), entries[0].Mode)
+	assert.Equal(t, downloader.FileMode(0o600), entries[1].Mode)
+
+	require.NoError(t, json.Unmarshal([]byte(`[{"path": "a", "mode": "0755"}]`), &entries))
+	assert.Equal(t, downloader.FileMode(0o755), entries[0].Mode)
+
+	require.Error(t, json.Unmarshal([]byte(`[{"path": "a", "mode": 420}]`), &entries))
+}
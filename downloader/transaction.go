@@ -0,0 +1,233 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stagedFile is a verified download, waiting to be put in place.
+type stagedFile struct {
+	path string
+	// stores the ETag, Last-Modified and hash files
+	finish func()
+}
+
+// Transaction updates a set of files that must be consistent with each other,
+// like a database and its index: either all of them are replaced, or none.
+type Transaction struct {
+	logger *logrus.Entry
+	items  []transactionItem
+}
+
+type transactionItem struct {
+	downloader *Downloader
+	url        string
+}
+
+// swappedFile is a file that has been replaced during the commit.
+type swappedFile struct {
+	destPath string
+	// the previous file, empty if there was none
+	backupPath string
+}
+
+// NewTransaction creates an empty transaction.
+func NewTransaction() *Transaction {
+	return &Transaction{
+		logger: nullLogger(),
+	}
+}
+
+// WithLogger sets the logger for the transaction.
+func (t *Transaction) WithLogger(logger *logrus.Entry) *Transaction {
+	t.logger = logger
+	return t
+}
+
+// Add adds a download to the transaction. Each downloader must have its own destination.
+func (t *Transaction) Add(d *Downloader, url string) *Transaction {
+	t.items = append(t.items, transactionItem{downloader: d, url: url})
+	return t
+}
+
+// Commit downloads all the files to temporary paths and verifies them. If all
+// downloads succeed, the files are renamed to their destination; if a rename fails,
+// the files already replaced are restored. Files that are up to date are not touched.
+//
+// The results are in the same order as the downloads were added. Stale copies
+// (WithStaleOnError) are not used, any failure aborts the whole transaction.
+func (t *Transaction) Commit(ctx context.Context) ([]*Result, error) {
+	seen := make(map[string]bool)
+
+	for _, item := range t.items {
+		if err := item.downloader.ValidateOptions(); err != nil {
+			return nil, fmt.Errorf("downloader options for %s: %w", item.url, err)
+		}
+
+		if seen[item.downloader.destPath] {
+			return nil, fmt.Errorf("destination %s is used more than once", item.downloader.destPath)
+		}
+
+		seen[item.downloader.destPath] = true
+	}
+
+	results := make([]*Result, len(t.items))
+
+	defer func() {
+		// whatever happened, the staged files are not needed anymore
+		for _, result := range results {
+			if result != nil && result.staged != nil {
+				_ = os.Remove(result.staged.path)
+			}
+		}
+	}()
+
+	for i, item := range t.items {
+		result, err := t.stage(ctx, item)
+		results[i] = result
+
+		if err != nil {
+			return nil, fmt.Errorf("transaction aborted, no file updated: %w", err)
+		}
+	}
+
+	if err := t.swap(results); err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.staged != nil {
+			result.staged.finish()
+			result.staged = nil
+		}
+	}
+
+	return results, nil
+}
+
+// stage downloads a file of the transaction, without replacing the destination.
+func (t *Transaction) stage(ctx context.Context, item transactionItem) (*Result, error) {
+	d := item.downloader
+
+	d.staging = true
+
+	defer func() {
+		d.staging = false
+	}()
+
+	result := &Result{}
+
+	downloaded, err := d.guardedDownload(ctx, item.url, result)
+	if err != nil {
+		return result, err
+	}
+
+	result.Outcome = OutcomeUpToDate
+	if downloaded {
+		result.Outcome = OutcomeDownloaded
+	}
+
+	return result, nil
+}
+
+// swap puts the staged files in place, or restores the previous ones if it can't.
+func (t *Transaction) swap(results []*Result) error {
+	var swapped []swappedFile
+
+	for i, result := range results {
+		if result.staged == nil {
+			continue
+		}
+
+		destPath := t.items[i].downloader.destPath
+
+		file, err := swapFile(result.staged.path, destPath)
+		if err != nil {
+			t.logger.Errorf("Failed to replace %s, rolling back: %s", destPath, err)
+
+			if rbErr := rollback(swapped); rbErr != nil {
+				return fmt.Errorf("failed to replace %s: %w (rollback failed: %w)", destPath, err, rbErr)
+			}
+
+			return fmt.Errorf("failed to replace %s, transaction rolled back: %w", destPath, err)
+		}
+
+		swapped = append(swapped, file)
+	}
+
+	for _, file := range swapped {
+		if file.backupPath != "" {
+			if err := os.Remove(file.backupPath); err != nil {
+				t.logger.Warnf("Failed to remove backup file: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// swapFile moves the current destination to a backup file, then renames the new one.
+func swapFile(newPath, destPath string) (swappedFile, error) {
+	file := swappedFile{destPath: destPath}
+
+	if _, err := os.Stat(destPath); err == nil {
+		backup, err := os.CreateTemp(filepath.Dir(destPath), filepath.Base(destPath)+".*.backup")
+		if err != nil {
+			return file, err
+		}
+
+		_ = backup.Close()
+
+		if err = replaceFile(destPath, backup.Name()); err != nil {
+			_ = os.Remove(backup.Name())
+			return file, err
+		}
+
+		file.backupPath = backup.Name()
+	}
+
+	if err := os.Rename(newPath, destPath); err != nil {
+		// put back the previous file, if any
+		if file.backupPath != "" {
+			if rbErr := replaceFile(file.backupPath, destPath); rbErr != nil {
+				return file, fmt.Errorf("%w (previous file left in %s: %w)", err, file.backupPath, rbErr)
+			}
+		}
+
+		return file, err
+	}
+
+	return file, nil
+}
+
+// rollback restores the previous files, in reverse order.
+func rollback(swapped []swappedFile) error {
+	var errs []error
+
+	for i := len(swapped) - 1; i >= 0; i-- {
+		file := swapped[i]
+
+		var err error
+
+		if file.backupPath != "" {
+			err = replaceFile(file.backupPath, file.destPath)
+		} else {
+			err = os.Remove(file.destPath)
+			if errors.Is(err, fs.ErrNotExist) {
+				err = nil
+			}
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restoring %s: %w", file.destPath, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
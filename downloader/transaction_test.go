@@ -0,0 +1,127 @@
+package downloader_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crowdsecurity/go-cs-lib/downloader"
+)
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("ETag", `"new"`)
+		_, _ = io.WriteString(w, "new "+r.URL.Path)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	db := filepath.Join(dir, "data.db")
+	index := filepath.Join(dir, "data.idx")
+
+	reset := func() {
+		require.NoError(t, os.RemoveAll(dir))
+		require.NoError(t, os.Mkdir(dir, 0o755))
+		require.NoError(t, os.WriteFile(db, []byte("old db"), 0o600))
+		require.NoError(t, os.WriteFile(index, []byte("old index"), 0o600))
+	}
+
+	requireContent := func(path, expected string) {
+		t.Helper()
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	requireFiles := func(expected ...string) {
+		t.Helper()
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+
+		assert.ElementsMatch(t, expected, names)
+	}
+
+	t.Run("all files are replaced", func(t *testing.T) {
+		reset()
+
+		results, err := downloader.NewTransaction().
+			Add(downloader.New().ToFile(db).WithETagFile(db+".etag"), ts.URL+"/db").
+			Add(downloader.New().ToFile(index), ts.URL+"/index").
+			Commit(ctx)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, downloader.OutcomeDownloaded, results[0].Outcome)
+		assert.Equal(t, downloader.OutcomeDownloaded, results[1].Outcome)
+
+		requireContent(db, "new /db")
+		requireContent(index, "new /index")
+		requireContent(db+".etag", `"new"`)
+		requireFiles("data.db", "data.db.etag", "data.idx")
+	})
+
+	t.Run("a failed download aborts the transaction", func(t *testing.T) {
+		reset()
+
+		_, err := downloader.NewTransaction().
+			Add(downloader.New().ToFile(db).WithETagFile(db+".etag"), ts.URL+"/db").
+			Add(downloader.New().ToFile(index), ts.URL+"/broken").
+			Commit(ctx)
+
+		var codeErr downloader.BadHTTPCodeError
+
+		require.ErrorAs(t, err, &codeErr)
+		require.ErrorContains(t, err, "transaction aborted, no file updated")
+
+		requireContent(db, "old db")
+		requireContent(index, "old index")
+		requireFiles("data.db", "data.idx")
+	})
+
+	t.Run("a failed rename is rolled back", func(t *testing.T) {
+		reset()
+
+		// a directory can't be replaced by a file
+		blocker := filepath.Join(dir, "blocker")
+		require.NoError(t, os.MkdirAll(filepath.Join(blocker, "sub"), 0o755))
+
+		_, err := downloader.NewTransaction().
+			Add(downloader.New().ToFile(db), ts.URL+"/db").
+			Add(downloader.New().ToFile(index), ts.URL+"/index").
+			Add(downloader.New().ToFile(blocker), ts.URL+"/blocker").
+			Commit(ctx)
+		require.ErrorContains(t, err, "failed to replace "+blocker+", transaction rolled back")
+
+		requireContent(db, "old db")
+		requireContent(index, "old index")
+		requireFiles("data.db", "data.idx", "blocker")
+	})
+
+	t.Run("a destination can't be used twice", func(t *testing.T) {
+		_, err := downloader.NewTransaction().
+			Add(downloader.New().ToFile(db), ts.URL+"/db").
+			Add(downloader.New().ToFile(db), ts.URL+"/index").
+			Commit(ctx)
+		require.ErrorContains(t, err, "is used more than once")
+	})
+}